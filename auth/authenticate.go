@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// CSRFHeader is the header cookie-authenticated clients must echo the CSRF
+// cookie in for unsafe methods (double-submit protection)
+const CSRFHeader = "X-CSRF-Token"
+
+// APIKeyHeader is the header service accounts send their API key in
+const APIKeyHeader = "X-API-Key"
+
+// Error is an authentication failure. Message is safe to show the client.
+type Error struct {
+	Status  int // 401, or 403 for a session request failing the CSRF check
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func unauthorized(message string) *Error {
+	return &Error{Status: 401, Message: message}
+}
+
+// Authenticate identifies the caller of a request. Clients may present either
+// a Bearer JWT in the Authorization header or a session cookie set at login.
+// Service accounts present an API key instead.
+func Authenticate(ctx *context.Context) (*Principal, error) {
+	if key := ctx.Input.Header(APIKeyHeader); key != "" {
+		return authenticateAPIKey(key)
+	}
+
+	authHeader := ctx.Input.Header("Authorization")
+	if authHeader == "" {
+		if ctx.GetCookie(config.SessionCookieName) != "" {
+			return authenticateSession(ctx)
+		}
+		return nil, unauthorized("Authorization header is required")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, unauthorized("Invalid authorization format. Use: Bearer <token>")
+	}
+	return authenticateToken(parts[1], ctx.Input.IP())
+}
+
+// authenticateToken authenticates an access token. The token must verify
+// against the signing key named in its kid header, must not have been revoked
+// on logout, its user must still be active and its device still signed in.
+func authenticateToken(token, ip string) (*Principal, error) {
+	// Special purpose tokens (e.g. pending MFA) aren't access tokens
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		return nil, unauthorized("Invalid or expired token")
+	}
+
+	revoked, err := models.IsAccessTokenRevoked(claims.Id)
+	if err != nil || revoked {
+		return nil, unauthorized("Token has been revoked")
+	}
+
+	// Suspended users lose access right away, not when the token expires
+	if active, err := models.IsUserActive(claims.UserID); err != nil || !active {
+		return nil, unauthorized("Account is suspended or no longer exists")
+	}
+
+	// The device is the login's refresh token family
+	if claims.FamilyID == "" {
+		return nil, unauthorized("Invalid or expired token")
+	}
+	if err := models.TouchDevice(claims.FamilyID, claims.UserID, ip); err != nil {
+		return nil, unauthorized("This device has been signed out")
+	}
+
+	role := models.Role(claims.Role)
+	return &Principal{
+		ID:        claims.UserID,
+		Username:  claims.Username,
+		Role:      role,
+		Scopes:    role.Permissions(),
+		Method:    MethodJWT,
+		MFA:       claims.MFA,
+		DeviceID:  claims.FamilyID,
+		TokenID:   claims.Id,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// authenticateSession authenticates a request by its session cookie. Unsafe
+// methods must also carry the CSRF token in the X-CSRF-Token header, matching
+// both the CSRF cookie and the token stored with the session.
+func authenticateSession(ctx *context.Context) (*Principal, error) {
+	session, err := models.ValidateSession(ctx.GetCookie(config.SessionCookieName))
+	if err != nil {
+		return nil, unauthorized("Invalid or expired session")
+	}
+
+	if isUnsafeMethod(ctx.Input.Method()) {
+		headerToken := ctx.Input.Header(CSRFHeader)
+		if headerToken != ctx.GetCookie(config.CSRFCookieName) || !session.ValidCSRFToken(headerToken) {
+			return nil, &Error{Status: 403, Message: "Missing or invalid CSRF token"}
+		}
+	}
+
+	user, err := models.GetUserByID(session.UserId)
+	if err != nil || user.SuspendedAt != nil {
+		return nil, unauthorized("Invalid or expired session")
+	}
+
+	// Sessions from before devices were tracked have none
+	var deviceID string
+	if session.DeviceId != nil {
+		deviceID = *session.DeviceId
+		if err := models.TouchDevice(deviceID, user.ID, ctx.Input.IP()); err != nil {
+			return nil, unauthorized("Invalid or expired session")
+		}
+	}
+
+	return &Principal{
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		Scopes:    user.Role.Permissions(),
+		Method:    MethodSession,
+		MFA:       session.Mfa,
+		DeviceID:  deviceID,
+		ExpiresAt: session.ExpiresAt,
+		Session:   session,
+	}, nil
+}
+
+// authenticateAPIKey authenticates a service account by its API key. The key
+// acts on behalf of the admin who created it, limited to the key's scopes.
+func authenticateAPIKey(plaintext string) (*Principal, error) {
+	key, err := models.AuthenticateAPIKey(plaintext)
+	if err != nil {
+		return nil, unauthorized("Invalid or expired API key")
+	}
+
+	return &Principal{
+		ID:       key.CreatedBy,
+		Username: "api-key:" + key.Name,
+		Scopes:   key.Permissions(),
+		Method:   MethodAPIKey,
+		APIKey:   key,
+	}, nil
+}
+
+// isUnsafeMethod reports whether the HTTP method can change server state
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+// Package auth identifies the caller of a request. The auth middleware
+// stores the resulting Principal in the request context, and handlers read it
+// from there instead of parsing credentials themselves.
+package auth
+
+import (
+	"cbc-backend/models"
+	"time"
+
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// Method is how a principal authenticated
+type Method string
+
+// Authentication methods
+const (
+	MethodJWT     Method = "jwt"
+	MethodSession Method = "session"
+	MethodAPIKey  Method = "api_key"
+)
+
+// principalKey is the context data key the principal is stored under
+const principalKey = "auth.principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	ID       string
+	Username string
+	Role     models.Role // Empty for API keys, which only carry scopes
+	Scopes   []models.Permission
+	Method   Method
+	MFA      bool   // Signed in with two-factor authentication
+	DeviceID string // The device signed in on, for JWT and session principals
+
+	// TokenID is the ID of a JWT principal's access token, so that it can be
+	// revoked on logout
+	TokenID   string
+	ExpiresAt time.Time // When the token or session expires
+
+	Session *models.Session // Set for session principals
+	APIKey  *models.APIKey  // Set for API key principals
+}
+
+// Can reports whether the principal was granted the permission, by its role
+// or by its API key scopes
+func (p *Principal) Can(permission models.Permission) bool {
+	for _, granted := range p.Scopes {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal is a user with the admin role. API
+// keys are never admins, even when created by one.
+func (p *Principal) IsAdmin() bool {
+	return p.Method != MethodAPIKey && p.Role == models.RoleAdmin
+}
+
+// SetPrincipal stores the principal in the request context
+func SetPrincipal(ctx *context.Context, p *Principal) {
+	ctx.Input.SetData(principalKey, p)
+}
+
+// FromContext returns the principal of the request, if it was authenticated
+func FromContext(ctx *context.Context) (*Principal, bool) {
+	p, ok := ctx.Input.GetData(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// UserID returns the ID of the request's principal, or "" for anonymous
+// requests
+func UserID(ctx *context.Context) string {
+	if p, ok := FromContext(ctx); ok {
+		return p.ID
+	}
+	return ""
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// command is a subcommand of the cbc-backend binary
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+// commands is filled in by init, since the commands themselves refer to it
+// for their usage text
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve", "Run the HTTP API server (default)", serve},
+		{"create-admin", "create-admin -username NAME [-email EMAIL] [-password-file FILE]",
+			"Create an admin account, or promote an existing user to admin", createAdmin},
+		{"reset-password", "reset-password -username NAME [-password-file FILE]",
+			"Set a user's password and sign them out everywhere", resetPassword},
+		{"rotate-signing-key", "rotate-signing-key",
+			"Create a new JWT signing key now instead of waiting for scheduled rotation", rotateSigningKey},
+		{"list-users", "list-users [-role ROLE] [-verified true|false] [-suspended true|false] [-page N] [-limit N]",
+			"List users, newest first", listUsers},
+		{"extract-resource-metadata", "extract-resource-metadata [-all]",
+			"Extract grade, subject, term, year and exam metadata from resource names and paths", extractResourceMetadata},
+	}
+}
+
+// runCommand runs the subcommand named by the first argument and returns the
+// process exit code. Without arguments the server is started.
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cbc-backend <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.description)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun 'cbc-backend <command> -h' for the flags of a command.")
+}
+
+// newFlagSet returns a flag set that prints the command's usage line
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: cbc-backend %s\n\n%s\n\n", cmd.usage, cmd.description)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses a command's flags and then runs setup. Flags come first
+// so that -h and bad flags don't need the database or migrate it.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return setup()
+}
+
+// readPassword reads a password from the file, "-" meaning stdin, or
+// prompts for it without echoing it. Passwords aren't accepted as flags so
+// that they don't end up in shell history or process listings.
+func readPassword(file string) (string, error) {
+	var r io.Reader = os.Stdin
+	if file == "" || file == "-" {
+		if file == "" {
+			fmt.Fprint(os.Stderr, "Password: ")
+			if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+				password, err := term.ReadPassword(fd)
+				fmt.Fprintln(os.Stderr)
+				if err != nil {
+					return "", fmt.Errorf("failed to read password: %v", err)
+				}
+				return string(password), nil
+			}
+		}
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// createAdmin creates the first admin of a fresh install without going
+// through the API
+func createAdmin(args []string) error {
+	fs := newFlagSet("create-admin")
+	username := fs.String("username", "", "username of the admin")
+	email := fs.String("email", "", "email address (required for new accounts)")
+	passwordFile := fs.String("password-file", "", "read the password from this file (- for stdin) instead of prompting")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		fs.Usage()
+		return errors.New("-username is required")
+	}
+
+	// Existing accounts are promoted and keep their password
+	if user, err := models.GetUserByUsername(*username); err == nil {
+		if user.Role == models.RoleAdmin {
+			fmt.Printf("%s is already an admin\n", user.Username)
+			return nil
+		}
+		if _, err := models.SetUserRole(user.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := auditCLI(models.AuditRoleChanged, user.ID, map[string]interface{}{"from": user.Role, "to": models.RoleAdmin}); err != nil {
+			return err
+		}
+		fmt.Printf("Promoted %s (%s) to admin\n", user.Username, user.ID)
+		return nil
+	}
+
+	if *email == "" {
+		return errors.New("-email is required to create a new account")
+	}
+
+	password, err := readPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if err := utils.CheckPasswordPolicy(password, *username, *email); err != nil {
+		return err
+	}
+	hashedPassword, err := models.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// The operator vouches for the address, so it starts out verified
+	now := time.Now()
+	user := &models.User{
+		Username:   *username,
+		Password:   hashedPassword,
+		Email:      *email,
+		Role:       models.RoleAdmin,
+		VerifiedAt: &now,
+	}
+	if err := models.CreateUser(user); err != nil {
+		return fmt.Errorf("failed to create user: %v", err)
+	}
+	if err := auditCLI(models.AuditRoleChanged, user.ID, map[string]interface{}{"to": models.RoleAdmin, "created": true}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %s (%s)\n", user.Username, user.ID)
+	return nil
+}
+
+// resetPassword sets a user's password, e.g. for an admin who is locked out
+func resetPassword(args []string) error {
+	fs := newFlagSet("reset-password")
+	username := fs.String("username", "", "username of the account")
+	passwordFile := fs.String("password-file", "", "read the password from this file (- for stdin) instead of prompting")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		fs.Usage()
+		return errors.New("-username is required")
+	}
+
+	user, err := models.GetUserByUsername(*username)
+	if err != nil {
+		return fmt.Errorf("user %q not found", *username)
+	}
+
+	password, err := readPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if err := models.SetPassword(user, password); err != nil {
+		return err
+	}
+	if err := auditCLI(models.AuditPasswordReset, user.ID, nil); err != nil {
+		return err
+	}
+
+	// Let the user in right away even if they were locked out
+	if err := models.UnlockLogin(user.Username); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password reset for %s; existing sessions were signed out\n", user.Username)
+	return nil
+}
+
+// rotateSigningKey rotates the JWT signing key ahead of schedule, e.g. after
+// changing JWT_SIGNING_ALGORITHM or if a key may have leaked
+func rotateSigningKey(args []string) error {
+	fs := newFlagSet("rotate-signing-key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if _, err := models.RotateSigningKeys(true); err != nil {
+		return err
+	}
+	err := models.RecordAuditEvent(&models.AuditEvent{
+		ActorName:  "cli",
+		Action:     models.AuditSigningKeyRotated,
+		TargetType: "jwt_key",
+		Result:     models.AuditSuccess,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created a new signing key; servers start signing with it in %v.\n", models.SigningKeyPropagationDelay)
+	fmt.Println("Older keys keep verifying tokens until those tokens expire.")
+	return nil
+}
+
+// extractResourceMetadata backfills resource metadata. The server extracts
+// new resources periodically; -all extracts every resource again, e.g.
+// after editing the rules without bumping models.ResourceMetadataVersion.
+func extractResourceMetadata(args []string) error {
+	fs := newFlagSet("extract-resource-metadata")
+	all := fs.Bool("all", false, "extract every resource again, not only new and outdated ones")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	extracted, err := models.ExtractPendingResourceMetadata(*all)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Extracted the metadata of %d resource(s)\n", extracted)
+	return nil
+}
+
+// auditCLI records an action taken from the command line, where there is no
+// signed-in actor, in the audit log
+func auditCLI(action, userID string, details map[string]interface{}) error {
+	event := &models.AuditEvent{
+		ActorName:  "cli",
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+		Result:     models.AuditSuccess,
+	}
+	event.SetDetails(details)
+	return models.RecordAuditEvent(event)
+}
+
+// listUsers prints users as a table
+func listUsers(args []string) error {
+	fs := newFlagSet("list-users")
+	role := fs.String("role", "", "only users with this role")
+	verified := fs.String("verified", "", "only verified (true) or unverified (false) users")
+	suspended := fs.String("suspended", "", "only suspended (true) or active (false) users")
+	page := fs.Int("page", 1, "page number")
+	limit := fs.Int("limit", 50, "users per page")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *page < 1 || *limit < 1 {
+		return errors.New("-page and -limit must be positive")
+	}
+
+	var filter models.UserFilter
+	var err error
+	if *role != "" {
+		if filter.Role, err = models.ParseRole(*role); err != nil {
+			return err
+		}
+	}
+	if filter.Verified, err = parseBoolFlag("verified", *verified); err != nil {
+		return err
+	}
+	if filter.Suspended, err = parseBoolFlag("suspended", *suspended); err != nil {
+		return err
+	}
+
+	users, total, err := models.ListUsers(filter, *page, *limit)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tROLE\tVERIFIED\tSTATUS\tCREATED")
+	for _, u := range users {
+		status := "active"
+		if u.SuspendedAt != nil {
+			status = "suspended"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n", u.ID, u.Username, u.Email, u.Role,
+			u.VerifiedAt != nil, status, u.CreatedAt.Format("2006-01-02"))
+	}
+	tw.Flush()
+	fmt.Printf("\nShowing %d of %d users (page %d)\n", len(users), total, *page)
+	return nil
+}
+
+func parseBoolFlag(name, value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch value {
+	case "true":
+		b := true
+		return &b, nil
+	case "false":
+		b := false
+		return &b, nil
+	}
+	return nil, fmt.Errorf("-%s must be true or false", name)
+}
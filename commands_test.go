@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBoolFlag(t *testing.T) {
+	value, err := parseBoolFlag("verified", "")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	value, err = parseBoolFlag("verified", "true")
+	if assert.NoError(t, err) && assert.NotNil(t, value) {
+		assert.True(t, *value)
+	}
+
+	value, err = parseBoolFlag("verified", "false")
+	if assert.NoError(t, err) && assert.NotNil(t, value) {
+		assert.False(t, *value)
+	}
+
+	_, err = parseBoolFlag("verified", "yes")
+	assert.EqualError(t, err, "-verified must be true or false")
+}
+
+func TestRunCommandExitCodes(t *testing.T) {
+	assert.Equal(t, 0, runCommand([]string{"help"}))
+	assert.Equal(t, 2, runCommand([]string{"bogus"}))
+
+	// Asking for a command's usage doesn't need a database
+	assert.Equal(t, 0, runCommand([]string{"create-admin", "-h"}))
+}
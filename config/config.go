@@ -3,13 +3,98 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 var (
 	// JWT settings
+	// JWTSecret verifies HS256 tokens issued before asymmetric signing, for
+	// one access token lifetime after the first signing key. It is optional
+	// and can be removed after that.
 	JWTSecret []byte
+	// JWTSigningAlgorithm is RS256 or EdDSA; changing it takes effect at the
+	// next key rotation
+	JWTSigningAlgorithm string
+	// JWTKeyRotationInterval is how long a signing key is used before a new
+	// one replaces it
+	JWTKeyRotationInterval time.Duration
+
+	// Token lifetimes
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Cookie session settings
+	SessionTTL        time.Duration // Idle timeout, extended on every request
+	SessionMaxAge     time.Duration // Absolute lifetime regardless of activity
+	SessionCookieName string
+	CSRFCookieName    string
+	CookieSecure      bool
+
+	// Mail settings
+	MailDriver      string // smtp or outbox
+	MailFrom        string
+	MailOutboxDir   string
+	MailQueueSize   int
+	MailWorkers     int
+	MailMaxAttempts int
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUsername    string
+	SMTPPassword    string
+
+	// FrontendURL is the base URL used for links in emails
+	FrontendURL string
+	// CORSAllowedOrigins are the origins allowed to make credentialed
+	// cross-origin requests, e.g. https://app.cbcexams.co.ke
+	CORSAllowedOrigins []string
+
+	// OpenID Connect login (e.g. Google). Disabled when OIDCIssuer is empty.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCStateTTL     time.Duration
+
+	// API keys for service accounts
+	APIKeyDefaultTTL time.Duration
+	APIKeyMaxTTL     time.Duration
+
+	// Two-factor authentication
+	MFAIssuer        string
+	MFAPendingTTL    time.Duration
+	MFARequiredRoles []string // Roles that must sign in with 2FA
+
+	// ErasureGracePeriod is how long a deleted account waits before its
+	// data is erased, during which the deletion can be cancelled
+	ErasureGracePeriod time.Duration
+	// TrashRetention is how long deleted users, jobs and uploads stay in the
+	// trash, where admins can restore them, before they are purged
+	TrashRetention time.Duration
+
+	// InvitationTTL is how long an organization invitation link stays valid
+	InvitationTTL time.Duration
+
+	// Password policy
+	PasswordMinLength int
+	BcryptCost        int
+
+	// Login throttling. Failures are counted per username and per IP within
+	// LoginWindow; the lockout ends early if the window passes first.
+	LoginWindow          time.Duration
+	LoginDelayThreshold  int // Failures allowed before delays start
+	LoginMaxDelay        time.Duration
+	LoginMaxFailures     int // Per account, before lockout
+	LoginIPMaxFailures   int // Per IP, before lockout
+	LoginLockoutDuration time.Duration
+
+	// UnverifiedRestrictedPermissions are denied to users who haven't
+	// verified their email address
+	UnverifiedRestrictedPermissions []string
 
 	// Database settings
 	DBUser     string
@@ -28,12 +113,91 @@ func LoadConfig() error {
 	}
 
 	// JWT settings
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return fmt.Errorf("JWT_SECRET environment variable is required")
+	if jwtSecret := os.Getenv("JWT_SECRET"); jwtSecret != "" {
+		JWTSecret = []byte(jwtSecret)
+	}
+	JWTSigningAlgorithm = getEnvWithDefault("JWT_SIGNING_ALGORITHM", "RS256")
+	if JWTSigningAlgorithm != "RS256" && JWTSigningAlgorithm != "EdDSA" {
+		return fmt.Errorf("JWT_SIGNING_ALGORITHM must be RS256 or EdDSA, got %q", JWTSigningAlgorithm)
 	}
-	fmt.Printf("Loaded JWT secret (first 10 chars): %s...\n", jwtSecret[:10])
-	JWTSecret = []byte(jwtSecret)
+	JWTKeyRotationInterval = getDurationWithDefault("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+
+	// Token lifetimes
+	AccessTokenTTL = getDurationWithDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+	RefreshTokenTTL = getDurationWithDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	// Cookie session settings
+	SessionTTL = getDurationWithDefault("SESSION_TTL", 24*time.Hour)
+	SessionMaxAge = getDurationWithDefault("SESSION_MAX_AGE", 7*24*time.Hour)
+	SessionCookieName = getEnvWithDefault("SESSION_COOKIE_NAME", "cbc_session")
+	CSRFCookieName = getEnvWithDefault("CSRF_COOKIE_NAME", "cbc_csrf")
+	CookieSecure = getEnvWithDefault("COOKIE_SECURE", "true") == "true"
+
+	// Mail settings
+	MailDriver = getEnvWithDefault("MAIL_DRIVER", "outbox")
+	MailFrom = getEnvWithDefault("MAIL_FROM", "CBC Exams <no-reply@cbcexams.co.ke>")
+	MailOutboxDir = getEnvWithDefault("MAIL_OUTBOX_DIR", "outbox")
+	MailQueueSize = getIntWithDefault("MAIL_QUEUE_SIZE", 100)
+	MailWorkers = getIntWithDefault("MAIL_WORKERS", 2)
+	MailMaxAttempts = getIntWithDefault("MAIL_MAX_ATTEMPTS", 5)
+	SMTPHost = getEnvWithDefault("SMTP_HOST", "localhost")
+	SMTPPort = getEnvWithDefault("SMTP_PORT", "587")
+	SMTPUsername = os.Getenv("SMTP_USERNAME")
+	SMTPPassword = os.Getenv("SMTP_PASSWORD")
+
+	FrontendURL = strings.TrimRight(getEnvWithDefault("FRONTEND_URL", "http://localhost:3000"), "/")
+	CORSAllowedOrigins = getListWithDefault("CORS_ALLOWED_ORIGINS", FrontendURL)
+	for i, origin := range CORSAllowedOrigins {
+		if origin == "*" {
+			return fmt.Errorf("CORS_ALLOWED_ORIGINS must list origins; * would let any site use the session cookie")
+		}
+		CORSAllowedOrigins[i] = strings.TrimRight(origin, "/")
+	}
+
+	// OpenID Connect login
+	OIDCIssuer = strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	OIDCRedirectURL = getEnvWithDefault("OIDC_REDIRECT_URL", FrontendURL+"/oidc/callback")
+	OIDCScopes = getListWithDefault("OIDC_SCOPES", "openid,email,profile")
+	OIDCStateTTL = getDurationWithDefault("OIDC_STATE_TTL", 10*time.Minute)
+
+	// API keys
+	APIKeyDefaultTTL = getDurationWithDefault("API_KEY_DEFAULT_TTL", 90*24*time.Hour)
+	APIKeyMaxTTL = getDurationWithDefault("API_KEY_MAX_TTL", 365*24*time.Hour)
+
+	// Two-factor authentication
+	MFAIssuer = getEnvWithDefault("MFA_ISSUER", "CBC Exams")
+	MFAPendingTTL = getDurationWithDefault("MFA_PENDING_TTL", 5*time.Minute)
+	MFARequiredRoles = getListWithDefault("MFA_REQUIRED_ROLES", "none")
+
+	// Account erasure and the trash
+	ErasureGracePeriod = getDurationWithDefault("ERASURE_GRACE_PERIOD", 14*24*time.Hour)
+	TrashRetention = getDurationWithDefault("TRASH_RETENTION", 30*24*time.Hour)
+
+	// Organizations
+	InvitationTTL = getDurationWithDefault("INVITATION_TTL", 7*24*time.Hour)
+
+	// Password policy
+	PasswordMinLength = getIntWithDefault("PASSWORD_MIN_LENGTH", 10)
+	BcryptCost = getIntWithDefault("BCRYPT_COST", 12)
+
+	// Login throttling
+	LoginWindow = getDurationWithDefault("LOGIN_WINDOW", 15*time.Minute)
+	LoginDelayThreshold = getIntWithDefault("LOGIN_DELAY_THRESHOLD", 3)
+	LoginMaxDelay = getDurationWithDefault("LOGIN_MAX_DELAY", 30*time.Second)
+	LoginMaxFailures = getIntWithDefault("LOGIN_MAX_FAILURES", 10)
+	LoginIPMaxFailures = getIntWithDefault("LOGIN_IP_MAX_FAILURES", 50)
+	LoginLockoutDuration = getDurationWithDefault("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	if LoginDelayThreshold < 1 {
+		return fmt.Errorf("LOGIN_DELAY_THRESHOLD must be at least 1, got %d", LoginDelayThreshold)
+	}
+	if LoginMaxFailures <= LoginDelayThreshold || LoginIPMaxFailures <= LoginDelayThreshold {
+		return fmt.Errorf("LOGIN_MAX_FAILURES (%d) and LOGIN_IP_MAX_FAILURES (%d) must be above LOGIN_DELAY_THRESHOLD (%d)",
+			LoginMaxFailures, LoginIPMaxFailures, LoginDelayThreshold)
+	}
+
+	UnverifiedRestrictedPermissions = getListWithDefault("UNVERIFIED_RESTRICTED_PERMISSIONS", "resources:write,jobs:write")
 
 	// Database settings
 	DBUser = getEnvWithDefault("DB_USER", "postgres")
@@ -53,6 +217,53 @@ func getEnvWithDefault(key, defaultValue string) string {
 	return value
 }
 
+// getListWithDefault parses a comma-separated list from the environment.
+// Setting the variable to "none" yields an empty list.
+func getListWithDefault(key, defaultValue string) []string {
+	value := getEnvWithDefault(key, defaultValue)
+	if value == "none" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getIntWithDefault parses an integer from the environment, falling back to
+// the default if unset or invalid
+func getIntWithDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Invalid number for %s (%q), using default %d\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getDurationWithDefault parses a Go duration string (e.g. "15m", "720h")
+// from the environment, falling back to the default if unset or invalid
+func getDurationWithDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Invalid duration for %s (%q), using default %v\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
 func GetDBConnString() string {
 	return fmt.Sprintf(
 		"user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
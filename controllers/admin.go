@@ -0,0 +1,412 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	beego "github.com/beego/beego/v2/server/web"
+)
+
+// AdminController handles administrative user operations. Every route is
+// restricted to the admin role in the router.
+type AdminController struct {
+	beego.Controller
+}
+
+// UnlockUser clears the failed login attempts that locked a user's account
+func (c *AdminController) UnlockUser() {
+	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
+	user, err := models.GetUserByID(uid)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return
+	}
+
+	err = models.UnlockLogin(user.Username)
+	audit(c.Ctx, models.AuditUserUnlocked, models.AuditResult(err), "user", user.ID, nil)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to unlock user", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "User unlocked successfully", nil, nil)
+}
+
+// RoleChangeRequest represents the role change request body
+type RoleChangeRequest struct {
+	Role string `json:"role"`
+}
+
+// SuspensionRequest represents the suspend and reactivate request body
+type SuspensionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ListUsers returns a page of users. Filters: role, created_after and
+// created_before (RFC 3339 or YYYY-MM-DD), email_domain, verified and
+// suspended (true/false).
+func (c *AdminController) ListUsers() {
+	page, _ := c.GetInt("page", 1)
+	pageSize, _ := c.GetInt("page_size", 20)
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	var filter models.UserFilter
+	var err error
+	if role := c.GetString("role"); role != "" {
+		if filter.Role, err = models.ParseRole(role); err != nil {
+			c.badRequest("Invalid role filter", err)
+			return
+		}
+	}
+	if filter.CreatedAfter, err = parseDateParam(c.GetString("created_after")); err != nil {
+		c.badRequest("Invalid created_after", err)
+		return
+	}
+	if filter.CreatedBefore, err = parseDateParam(c.GetString("created_before")); err != nil {
+		c.badRequest("Invalid created_before", err)
+		return
+	}
+	filter.EmailDomain = strings.ToLower(strings.TrimSpace(c.GetString("email_domain")))
+	if filter.Verified, err = parseBoolParam(c.GetString("verified")); err != nil {
+		c.badRequest("Invalid verified filter", err)
+		return
+	}
+	if filter.Suspended, err = parseBoolParam(c.GetString("suspended")); err != nil {
+		c.badRequest("Invalid suspended filter", err)
+		return
+	}
+
+	users, totalItems, err := models.ListUsers(filter, page, pageSize)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list users", nil, err)
+		return
+	}
+
+	pagination := map[string]interface{}{
+		"current_page": page,
+		"total_pages":  (totalItems + int64(pageSize) - 1) / int64(pageSize),
+		"page_size":    pageSize,
+		"total_items":  totalItems,
+		"items":        users,
+	}
+	utils.SendResponse(&c.Controller, true, "", pagination, nil)
+}
+
+// GetUser returns a user's account details
+func (c *AdminController) GetUser() {
+	user, ok := c.targetUser()
+	if !ok {
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "", user, nil)
+}
+
+// ChangeRole sets a user's role, including demoting admins. The last active
+// admin can't be demoted.
+func (c *AdminController) ChangeRole() {
+	var req RoleChangeRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+		c.badRequest("Invalid request body", err)
+		return
+	}
+	role, err := models.ParseRole(req.Role)
+	if err != nil {
+		c.badRequest("Invalid role", err)
+		return
+	}
+
+	target, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	user, err := models.SetUserRole(target.ID, role)
+	audit(c.Ctx, models.AuditRoleChanged, models.AuditResult(err), "user", target.ID, map[string]interface{}{
+		"from": target.Role,
+		"to":   role,
+	})
+	if err != nil {
+		c.sendUserChangeError("Failed to change role", err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Role changed successfully", user, nil)
+}
+
+// SuspendUser blocks a user from signing in and ends their sessions
+func (c *AdminController) SuspendUser() {
+	var req SuspensionRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.badRequest("reason is required", err)
+		return
+	}
+
+	target, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	user, err := models.SuspendUser(target.ID, strings.TrimSpace(req.Reason))
+	audit(c.Ctx, models.AuditUserSuspended, models.AuditResult(err), "user", target.ID, map[string]interface{}{
+		"reason": strings.TrimSpace(req.Reason),
+	})
+	if err != nil {
+		c.sendUserChangeError("Failed to suspend user", err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "User suspended", user, nil)
+}
+
+// ReactivateUser lifts a user's suspension
+func (c *AdminController) ReactivateUser() {
+	var req SuspensionRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.badRequest("reason is required", err)
+		return
+	}
+
+	target, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	user, err := models.ReactivateUser(target.ID, strings.TrimSpace(req.Reason))
+	audit(c.Ctx, models.AuditUserReactivated, models.AuditResult(err), "user", target.ID, map[string]interface{}{
+		"reason": strings.TrimSpace(req.Reason),
+	})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to reactivate user", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "User reactivated", user, nil)
+}
+
+// ForcePasswordReset signs the user out everywhere, blocks password logins
+// until they choose a new password, and emails them a reset link
+func (c *AdminController) ForcePasswordReset() {
+	user, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	err := models.RequirePasswordReset(user.ID)
+	audit(c.Ctx, models.AuditPasswordResetForced, models.AuditResult(err), "user", user.ID, nil)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to require password reset", nil, err)
+		return
+	}
+
+	if _, err := sendPasswordResetEmail(user.Email); err != nil {
+		utils.SendResponse(&c.Controller, false, "Password reset required, but the reset email could not be sent", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Password reset required. A reset link has been emailed to the user.", nil, nil)
+}
+
+// DeleteUser schedules any user's account for erasure, or with
+// immediate=true moves it to the trash right away
+func (c *AdminController) DeleteUser() {
+	user, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	immediate, err := parseBoolParam(c.GetString("immediate"))
+	if err != nil {
+		c.badRequest("Invalid immediate", err)
+		return
+	}
+	if immediate == nil || !*immediate {
+		scheduleErasure(&c.Controller, user.ID)
+		return
+	}
+
+	err = models.DeleteUser(user.ID)
+	audit(c.Ctx, models.AuditUserDeleted, models.AuditResult(err), "user", user.ID, map[string]interface{}{
+		"username": user.Username,
+	})
+	if err != nil {
+		c.sendUserChangeError("Failed to delete user", err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "User moved to the trash", nil, nil)
+}
+
+// targetUser loads the user named by the :uid parameter, responding with 404
+// if there is none
+func (c *AdminController) targetUser() (*models.User, bool) {
+	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
+	user, err := models.GetUserByID(uid)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// sendUserChangeError responds to a failed role change or suspension
+func (c *AdminController) sendUserChangeError(message string, err error) {
+	if errors.Is(err, models.ErrLastAdmin) {
+		c.Ctx.Output.SetStatus(409)
+	}
+	utils.SendResponse(&c.Controller, false, message, nil, err)
+}
+
+func (c *AdminController) badRequest(message string, err error) {
+	c.Ctx.Output.SetStatus(400)
+	utils.SendResponse(&c.Controller, false, message, nil, err)
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD date. An
+// empty value gives the zero time.
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseBoolParam parses an optional boolean query parameter
+func parseBoolParam(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateAPIKeyRequest represents the API key creation request body
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // Defaults to API_KEY_DEFAULT_TTL from now
+}
+
+// ListAPIKeys lists all API keys without their secrets
+func (c *AdminController) ListAPIKeys() {
+	keys, err := models.ListAPIKeys()
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list API keys", nil, err)
+		return
+	}
+
+	items := make([]map[string]interface{}, len(keys))
+	for i := range keys {
+		items[i] = apiKeyResponse(&keys[i])
+	}
+	utils.SendResponse(&c.Controller, true, "", items, nil)
+}
+
+// CreateAPIKey creates a scoped API key for a service account. The key is
+// only returned in this response.
+func (c *AdminController) CreateAPIKey() {
+	var req CreateAPIKeyRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Invalid request body", nil, err)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "name and scopes are required", nil, nil)
+		return
+	}
+
+	scopes := make([]models.Permission, len(req.Scopes))
+	for i, s := range req.Scopes {
+		scope, err := models.ParsePermission(s)
+		if err != nil {
+			c.Ctx.Output.SetStatus(400)
+			utils.SendResponse(&c.Controller, false, "Invalid scope", nil, err)
+			return
+		}
+		scopes[i] = scope
+	}
+
+	expiresAt := time.Now().Add(config.APIKeyDefaultTTL)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(time.Now()) || expiresAt.After(time.Now().Add(config.APIKeyMaxTTL)) {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, fmt.Sprintf("expires_at must be in the future and at most %s away", config.APIKeyMaxTTL), nil, nil)
+		return
+	}
+
+	adminID := auth.UserID(c.Ctx)
+	key, plaintext, err := models.CreateAPIKey(req.Name, scopes, expiresAt, adminID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to create API key", nil, err)
+		return
+	}
+	audit(c.Ctx, models.AuditAPIKeyCreated, models.AuditSuccess, "api_key", key.ID, map[string]interface{}{
+		"name":   key.Name,
+		"scopes": key.Permissions(),
+	})
+
+	response := apiKeyResponse(key)
+	response["key"] = plaintext
+	c.Ctx.Output.SetStatus(201)
+	utils.SendResponse(&c.Controller, true, "API key created. Store the key now; it won't be shown again.", response, nil)
+}
+
+// RevokeAPIKey revokes an API key immediately
+func (c *AdminController) RevokeAPIKey() {
+	id := strings.Trim(c.Ctx.Input.Param(":id"), "{}")
+	err := models.RevokeAPIKey(id)
+	audit(c.Ctx, models.AuditAPIKeyRevoked, models.AuditResult(err), "api_key", id, nil)
+	if err != nil {
+		if err == orm.ErrNoRows {
+			c.Ctx.Output.SetStatus(404)
+			utils.SendResponse(&c.Controller, false, "API key not found or already revoked", nil, nil)
+			return
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to revoke API key", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "API key revoked", nil, nil)
+}
+
+// apiKeyResponse describes an API key with its scopes as a list
+func apiKeyResponse(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"scopes":       key.Permissions(),
+		"created_by":   key.CreatedBy,
+		"expires_at":   key.ExpiresAt,
+		"last_used_at": key.LastUsedAt,
+		"revoked_at":   key.RevokedAt,
+		"created_at":   key.CreatedAt,
+	}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"errors"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// DeviceView is a signed-in device as its user sees it
+type DeviceView struct {
+	models.Device
+	Current bool `json:"current"` // The device making the request
+}
+
+// ListMySessions returns the devices the signed-in user is signed in on,
+// most recently seen first
+func (c *UserController) ListMySessions() {
+	principal, _ := auth.FromContext(c.Ctx)
+	devices, err := models.ListDevices(principal.ID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list sessions", nil, err)
+		return
+	}
+
+	views := make([]DeviceView, len(devices))
+	for i, device := range devices {
+		views[i] = DeviceView{Device: device, Current: device.ID == principal.DeviceID}
+	}
+	utils.SendResponse(&c.Controller, true, "", views, nil)
+}
+
+// SignOutMySession signs one of the signed-in user's devices out
+func (c *UserController) SignOutMySession() {
+	userID := auth.UserID(c.Ctx)
+	id := c.Ctx.Input.Param(":id")
+
+	err := models.SignOutDevice(userID, id)
+	if errors.Is(err, orm.ErrNoRows) {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Session not found", nil, nil)
+		return
+	}
+	audit(c.Ctx, models.AuditDeviceSignedOut, models.AuditResult(err), "user", userID, map[string]interface{}{"device_id": id})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to sign out session", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Session signed out", nil, nil)
+}
+
+// SignOutEverywhere signs a user out of every device, e.g. when their
+// account is compromised. Their access tokens stop working right away.
+func (c *AdminController) SignOutEverywhere() {
+	user, ok := c.targetUser()
+	if !ok {
+		return
+	}
+
+	err := models.SignOutEverywhere(user.ID)
+	audit(c.Ctx, models.AuditSignedOutEverywhere, models.AuditResult(err), "user", user.ID, nil)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to sign out user", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "User signed out everywhere", nil, nil)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"cbc-backend/auth"
 	"cbc-backend/models"
 	"cbc-backend/utils"
 	"encoding/json"
@@ -85,13 +86,27 @@ func (c *JobController) Post() {
 		return
 	}
 
+	job.PostedBy = auth.UserID(c.Ctx)
+
+	// Only organization owners and admins can post on its behalf
+	if job.OrganizationID != nil {
+		member, err := models.GetMembership(*job.OrganizationID, job.PostedBy)
+		if err != nil || !member.Role.AtLeast(models.OrgAdmin) {
+			c.Ctx.Output.SetStatus(403)
+			utils.SendResponse(&c.Controller, false, "You can't post jobs for this organization", nil, nil)
+			return
+		}
+	}
+
 	id, err := models.AddJob(job)
 	if err != nil {
+		audit(c.Ctx, models.AuditJobCreated, models.AuditFailure, "job", "", map[string]interface{}{"title": job.Title})
 		utils.SendResponse(&c.Controller, false, "Failed to create job", nil, err)
 		return
 	}
 
 	job.Id = int(id)
+	audit(c.Ctx, models.AuditJobCreated, models.AuditSuccess, "job", strconv.Itoa(job.Id), map[string]interface{}{"title": job.Title})
 	utils.SendResponse(&c.Controller, true, "Job created successfully", job, nil)
 }
 
@@ -103,6 +118,10 @@ func (c *JobController) Put() {
 		return
 	}
 
+	if !c.checkJobOwner(id, "update") {
+		return
+	}
+
 	var job models.Job
 	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &job); err != nil {
 		utils.SendResponse(&c.Controller, false, "", nil, err)
@@ -111,6 +130,7 @@ func (c *JobController) Put() {
 
 	job.Id = id
 	err = models.UpdateJob(&job)
+	audit(c.Ctx, models.AuditJobUpdated, models.AuditResult(err), "job", strconv.Itoa(id), map[string]interface{}{"title": job.Title})
 	if err != nil {
 		utils.SendResponse(&c.Controller, false, "", nil, err)
 		return
@@ -127,7 +147,12 @@ func (c *JobController) Delete() {
 		return
 	}
 
+	if !c.checkJobOwner(id, "delete") {
+		return
+	}
+
 	err = models.DeleteJob(id)
+	audit(c.Ctx, models.AuditJobDeleted, models.AuditResult(err), "job", strconv.Itoa(id), nil)
 	if err != nil {
 		utils.SendResponse(&c.Controller, false, "", nil, err)
 		return
@@ -136,6 +161,25 @@ func (c *JobController) Delete() {
 	utils.SendResponse(&c.Controller, true, "Job deleted successfully", nil, nil)
 }
 
+// checkJobOwner responds with 404 or 403 and returns false unless the job
+// exists and was posted by the current user. Admins can change any job.
+func (c *JobController) checkJobOwner(id int, action string) bool {
+	job := models.Job{Id: id}
+	if err := models.GetJob(&job); err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Job not found", nil, nil)
+		return false
+	}
+
+	principal, _ := auth.FromContext(c.Ctx)
+	if job.PostedBy != principal.ID && !principal.IsAdmin() {
+		c.Ctx.Output.SetStatus(403)
+		utils.SendResponse(&c.Controller, false, "Unauthorized to "+action+" this job", nil, nil)
+		return false
+	}
+	return true
+}
+
 func init() {
 	if err := os.MkdirAll("uploads", 0755); err != nil {
 		fmt.Printf("Failed to create uploads directory: %v\n", err)
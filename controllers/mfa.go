@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MFACodeRequest represents a request carrying a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFALoginRequest represents the second step of a two-factor login
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// LoginMFA completes a login for a user with two-factor authentication by
+// exchanging the mfa_token from Login and a TOTP or recovery code for tokens
+func (c *UserController) LoginMFA() {
+	var req MFALoginRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || req.MFAToken == "" || req.Code == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "mfa_token and code are required", nil, err)
+		return
+	}
+
+	claims, err := utils.ValidateMFAPendingToken(req.MFAToken)
+	if err != nil {
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid or expired MFA token. Please sign in again.", nil, nil)
+		return
+	}
+
+	// Codes are throttled together with passwords so that they can't be
+	// guessed either
+	ip := c.Ctx.Input.IP()
+	wait, err := models.LoginRetryAfter(claims.Username, ip)
+	if err != nil {
+		fmt.Printf("Failed to check login attempts: %v\n", err)
+	}
+	if wait > 0 {
+		c.Ctx.Output.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.Ctx.Output.SetStatus(429)
+		utils.SendResponse(&c.Controller, false, "Too many login attempts. Please try again later.", nil, nil)
+		return
+	}
+
+	user, err := models.GetUserByID(claims.UserID)
+	if err != nil {
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid or expired MFA token. Please sign in again.", nil, nil)
+		return
+	}
+
+	if err := models.VerifyMFACode(user.ID, req.Code); err != nil {
+		if err := models.RecordLoginAttempt(user.Username, ip, false); err != nil {
+			fmt.Printf("Failed to record login attempt: %v\n", err)
+		}
+		auditLogin(c.Ctx, user, "", models.AuditFailure, map[string]interface{}{"reason": "invalid_mfa_code"})
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid two-factor authentication code", nil, nil)
+		return
+	}
+
+	if !c.checkNotSuspended(user, "mfa") {
+		return
+	}
+	c.completeLogin(user, "mfa", true)
+}
+
+// EnrollMFA starts two-factor enrollment for the signed-in user and returns
+// the secret to add to an authenticator app
+func (c *UserController) EnrollMFA() {
+	userID := auth.UserID(c.Ctx)
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return
+	}
+
+	secret, err := models.StartMFAEnrollment(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			c.Ctx.Output.SetStatus(409)
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to start two-factor enrollment", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Scan the code with your authenticator app, then confirm with a code", map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(config.MFAIssuer, user.Username, secret),
+	}, nil)
+}
+
+// ConfirmMFA enables two-factor authentication once the user submits a
+// valid code, and returns the one-time recovery codes
+func (c *UserController) ConfirmMFA() {
+	var req MFACodeRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || req.Code == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "code is required", nil, err)
+		return
+	}
+
+	userID := auth.UserID(c.Ctx)
+	codes, err := models.ConfirmMFAEnrollment(userID, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidMFACode):
+			c.Ctx.Output.SetStatus(400)
+		case errors.Is(err, models.ErrMFAAlreadyEnabled):
+			c.Ctx.Output.SetStatus(409)
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to enable two-factor authentication", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Two-factor authentication enabled. Store your recovery codes somewhere safe; they won't be shown again.", map[string]interface{}{
+		"recovery_codes": codes,
+	}, nil)
+}
+
+// DisableMFA turns off two-factor authentication after checking a current
+// TOTP or recovery code
+func (c *UserController) DisableMFA() {
+	var req MFACodeRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || req.Code == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "code is required", nil, err)
+		return
+	}
+
+	userID := auth.UserID(c.Ctx)
+	if err := models.VerifyMFACode(userID, req.Code); err != nil {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Failed to disable two-factor authentication", nil, err)
+		return
+	}
+
+	if err := models.DisableMFA(userID); err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to disable two-factor authentication", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Two-factor authentication disabled", nil, nil)
+}
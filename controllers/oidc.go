@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"cbc-backend/oidc"
+	"cbc-backend/utils"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/beego/beego/v2/core/logs"
+)
+
+// oidcStateCookie ties a single sign-on to the browser that started it, so
+// that nobody can get a victim to finish a login they started and sign the
+// victim in to their account
+const oidcStateCookie = "cbc_oidc_state"
+
+// setOIDCStateCookie sets or, with an empty value, expires the state cookie
+func setOIDCStateCookie(w http.ResponseWriter, value string) {
+	maxAge := int(config.OIDCStateTTL.Seconds())
+	if value == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCLogin starts a sign in with the configured OpenID Connect provider
+// (e.g. Google). The client sends the browser to the returned
+// authorization_url; the provider redirects back to OIDC_REDIRECT_URL, whose
+// page passes the code and state on to OIDCCallback.
+func (c *UserController) OIDCLogin() {
+	if oidc.Default == nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Single sign-on is not configured", nil, oidc.ErrNotConfigured)
+		return
+	}
+
+	login, err := models.CreateOIDCLogin()
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to start single sign-on", nil, err)
+		return
+	}
+
+	authURL, err := oidc.Default.AuthCodeURL(c.Ctx.Request.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		c.Ctx.Output.SetStatus(502)
+		utils.SendResponse(&c.Controller, false, "Failed to reach the identity provider", nil, err)
+		return
+	}
+
+	setOIDCStateCookie(c.Ctx.ResponseWriter, models.OIDCStateBinding(login.State))
+	utils.SendResponse(&c.Controller, true, "Redirect to the identity provider", map[string]interface{}{
+		"authorization_url": authURL,
+	}, nil)
+}
+
+// OIDCCallback completes a sign in with the provider. It redeems the code,
+// links the external account to a user by verified email, creating the user
+// if needed, and responds like Login.
+func (c *UserController) OIDCCallback() {
+	if oidc.Default == nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Single sign-on is not configured", nil, oidc.ErrNotConfigured)
+		return
+	}
+
+	// The user may have cancelled at the provider
+	if providerErr := c.GetString("error"); providerErr != "" {
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Sign in was cancelled or denied", nil,
+			fmt.Errorf("%s: %s", providerErr, c.GetString("error_description")))
+		return
+	}
+
+	code, state := c.GetString("code"), c.GetString("state")
+	if code == "" || state == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "code and state are required", nil, nil)
+		return
+	}
+
+	// The state must have been issued to this browser
+	binding := c.Ctx.GetCookie(oidcStateCookie)
+	if subtle.ConstantTimeCompare([]byte(binding), []byte(models.OIDCStateBinding(state))) != 1 {
+		auditLogin(c.Ctx, nil, "", models.AuditDenied, map[string]interface{}{"method": "oidc", "reason": "state_not_bound_to_browser"})
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Sign in was started in another browser. Please try again.", nil, nil)
+		return
+	}
+	setOIDCStateCookie(c.Ctx.ResponseWriter, "")
+
+	login, err := models.ConsumeOIDCLogin(state)
+	if err != nil {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Sign in expired. Please try again.", nil, err)
+		return
+	}
+
+	idToken, err := oidc.Default.Exchange(c.Ctx.Request.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		auditLogin(c.Ctx, nil, "", models.AuditFailure, map[string]interface{}{"method": "oidc", "error": err.Error()})
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Sign in with the identity provider failed", nil, err)
+		return
+	}
+
+	user, created, err := models.LinkExternalAccount(models.ExternalAccount{
+		Provider:      idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         idToken.Email,
+		EmailVerified: idToken.EmailVerified,
+		Name:          idToken.Name,
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrAccountDeleted) {
+			auditLogin(c.Ctx, nil, idToken.Email, models.AuditDenied, map[string]interface{}{"method": "oidc", "reason": err.Error()})
+			c.Ctx.Output.SetStatus(403)
+			utils.SendResponse(&c.Controller, false, err.Error(), nil, nil)
+			return
+		}
+		if errors.Is(err, models.ErrExternalEmailNotVerified) || errors.Is(err, models.ErrIdentityLinkRefused) {
+			auditLogin(c.Ctx, nil, idToken.Email, models.AuditDenied, map[string]interface{}{"method": "oidc", "reason": err.Error()})
+			c.Ctx.Output.SetStatus(409)
+			utils.SendResponse(&c.Controller, false, err.Error(), nil, nil)
+			return
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to sign in", nil, err)
+		return
+	}
+	if created {
+		logs.Info("Created user %s for %s identity %s", user.Username, idToken.Issuer, idToken.Subject)
+	}
+
+	c.signIn(user, "oidc")
+}
@@ -0,0 +1,527 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/mailer"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+	beego "github.com/beego/beego/v2/server/web"
+)
+
+// OrganizationController handles organizations such as schools, their
+// members and invitations. Routes only require a signed-in user; each
+// handler checks the caller's role in the organization.
+type OrganizationController struct {
+	beego.Controller
+}
+
+// OrganizationRequest represents the organization create and update body
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// InvitationRequest represents the invitation request body
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// InvitationResponseRequest represents the accept and decline request body
+type InvitationResponseRequest struct {
+	Token string `json:"token"`
+}
+
+// Create creates an organization with the caller as its owner
+func (c *OrganizationController) Create() {
+	name, ok := c.organizationName()
+	if !ok {
+		return
+	}
+
+	org, err := models.CreateOrganization(name, c.userID())
+	orgID := ""
+	if org != nil {
+		orgID = org.ID
+	}
+	audit(c.Ctx, models.AuditOrgCreated, models.AuditResult(err), "organization", orgID, map[string]interface{}{"name": name})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to create organization", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Organization created successfully", org, nil)
+}
+
+// List returns the organizations the caller belongs to, with their role
+func (c *OrganizationController) List() {
+	orgs, err := models.ListUserOrganizations(c.userID())
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list organizations", nil, err)
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "", orgs, nil)
+}
+
+// GetOne returns an organization and the caller's role in it
+func (c *OrganizationController) GetOne() {
+	member, ok := c.membership(models.OrgTeacher)
+	if !ok {
+		return
+	}
+	org, err := models.GetOrganization(member.OrganizationID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to get organization", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "", map[string]interface{}{
+		"id":         org.ID,
+		"name":       org.Name,
+		"created_by": org.CreatedBy,
+		"created_at": org.CreatedAt,
+		"role":       member.Role,
+	}, nil)
+}
+
+// Update renames an organization
+func (c *OrganizationController) Update() {
+	member, ok := c.membership(models.OrgAdmin)
+	if !ok {
+		return
+	}
+	name, ok := c.organizationName()
+	if !ok {
+		return
+	}
+
+	org, err := models.RenameOrganization(member.OrganizationID, name)
+	audit(c.Ctx, models.AuditOrgUpdated, models.AuditResult(err), "organization", member.OrganizationID, map[string]interface{}{"name": name})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to update organization", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Organization updated successfully", org, nil)
+}
+
+// Delete deletes an organization. Only owners can delete it.
+func (c *OrganizationController) Delete() {
+	member, ok := c.membership(models.OrgOwner)
+	if !ok {
+		return
+	}
+
+	err := models.DeleteOrganization(member.OrganizationID)
+	audit(c.Ctx, models.AuditOrgDeleted, models.AuditResult(err), "organization", member.OrganizationID, nil)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to delete organization", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Organization deleted successfully", nil, nil)
+}
+
+// ListMembers returns an organization's members
+func (c *OrganizationController) ListMembers() {
+	member, ok := c.membership(models.OrgTeacher)
+	if !ok {
+		return
+	}
+
+	members, err := models.ListMembers(member.OrganizationID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list members", nil, err)
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "", members, nil)
+}
+
+// ChangeMemberRole sets a member's role. Admins can only change the roles of
+// teachers and can't grant a role above their own; only owners can grant or
+// take away the owner role.
+func (c *OrganizationController) ChangeMemberRole() {
+	member, ok := c.membership(models.OrgAdmin)
+	if !ok {
+		return
+	}
+
+	var req RoleChangeRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+		c.badRequest("Invalid request body", err)
+		return
+	}
+	role, err := models.ParseOrgRole(req.Role)
+	if err != nil {
+		c.badRequest("Invalid role", err)
+		return
+	}
+
+	target, ok := c.targetMember(member.OrganizationID)
+	if !ok {
+		return
+	}
+	if (role == models.OrgOwner || target.Role == models.OrgOwner) && member.Role != models.OrgOwner {
+		c.forbidden("Only owners can change who owns the organization")
+		return
+	}
+	if member.Role != models.OrgOwner && (target.Role.AtLeast(member.Role) || !member.Role.AtLeast(role)) {
+		c.forbidden("You can't change this member's role")
+		return
+	}
+
+	updated, err := models.SetMemberRole(member.OrganizationID, target.UserID, role)
+	audit(c.Ctx, models.AuditOrgMemberRoleChanged, models.AuditResult(err), "organization", member.OrganizationID, map[string]interface{}{
+		"user_id": target.UserID,
+		"from":    target.Role,
+		"to":      role,
+	})
+	if err != nil {
+		c.sendMembershipError("Failed to change role", err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Role changed successfully", updated, nil)
+}
+
+// RemoveMember removes a member from an organization. Members may remove
+// themselves; admins may remove admins and teachers, and owners anyone.
+func (c *OrganizationController) RemoveMember() {
+	member, ok := c.membership(models.OrgTeacher)
+	if !ok {
+		return
+	}
+	target, ok := c.targetMember(member.OrganizationID)
+	if !ok {
+		return
+	}
+	if target.UserID != member.UserID {
+		if !member.Role.AtLeast(models.OrgAdmin) || !member.Role.AtLeast(target.Role) {
+			c.forbidden("You can't remove this member")
+			return
+		}
+	}
+
+	err := models.RemoveMember(member.OrganizationID, target.UserID)
+	audit(c.Ctx, models.AuditOrgMemberRemoved, models.AuditResult(err), "organization", member.OrganizationID, map[string]interface{}{
+		"user_id": target.UserID,
+	})
+	if err != nil {
+		c.sendMembershipError("Failed to remove member", err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Member removed successfully", nil, nil)
+}
+
+// Invite emails an invitation to join the organization. Only owners can
+// invite owners.
+func (c *OrganizationController) Invite() {
+	member, ok := c.membership(models.OrgAdmin)
+	if !ok {
+		return
+	}
+
+	var req InvitationRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+		c.badRequest("Invalid request body", err)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		c.badRequest("A valid email is required", nil)
+		return
+	}
+	role := models.OrgTeacher
+	if req.Role != "" {
+		var err error
+		if role, err = models.ParseOrgRole(req.Role); err != nil {
+			c.badRequest("Invalid role", err)
+			return
+		}
+	}
+	if role == models.OrgOwner && member.Role != models.OrgOwner {
+		c.forbidden("Only owners can invite owners")
+		return
+	}
+
+	org, err := models.GetOrganization(member.OrganizationID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to get organization", nil, err)
+		return
+	}
+
+	invitation, token, err := models.InviteToOrganization(org.ID, email, role, c.userID(), config.InvitationTTL)
+	audit(c.Ctx, models.AuditOrgMemberInvited, models.AuditResult(err), "organization", org.ID, map[string]interface{}{
+		"email": email,
+		"role":  role,
+	})
+	if err != nil {
+		c.sendMembershipError("Failed to invite member", err)
+		return
+	}
+
+	inviter, _ := auth.FromContext(c.Ctx)
+	err = mailer.SendTemplate(invitation.Email, "organization_invitation", map[string]interface{}{
+		"OrganizationName": org.Name,
+		"InvitedBy":        inviter.Username,
+		"Role":             string(invitation.Role),
+		"InvitationURL":    config.FrontendURL + "/invitations?token=" + url.QueryEscape(token),
+		"ExpiresIn":        fmt.Sprintf("%d days", int(config.InvitationTTL.Hours()/24)),
+	})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Invitation created, but the email could not be sent", invitation, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Invitation sent", invitation, nil)
+}
+
+// ListInvitations returns an organization's pending invitations
+func (c *OrganizationController) ListInvitations() {
+	member, ok := c.membership(models.OrgAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := models.ListInvitations(member.OrganizationID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list invitations", nil, err)
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "", invitations, nil)
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (c *OrganizationController) RevokeInvitation() {
+	member, ok := c.membership(models.OrgAdmin)
+	if !ok {
+		return
+	}
+
+	id := strings.Trim(c.Ctx.Input.Param(":id"), "{}")
+	err := models.RevokeInvitation(member.OrganizationID, id)
+	audit(c.Ctx, models.AuditOrgInvitationRevoked, models.AuditResult(err), "organization", member.OrganizationID, map[string]interface{}{
+		"invitation_id": id,
+	})
+	if err != nil {
+		if errors.Is(err, orm.ErrNoRows) {
+			c.Ctx.Output.SetStatus(404)
+			utils.SendResponse(&c.Controller, false, "Invitation not found", nil, nil)
+			return
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to revoke invitation", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Invitation revoked", nil, nil)
+}
+
+// AcceptInvitation adds the caller to the organization they were invited
+// to. The caller's email must be the invited address.
+func (c *OrganizationController) AcceptInvitation() {
+	token, ok := c.invitationToken()
+	if !ok {
+		return
+	}
+	user, err := models.GetUserByID(c.userID())
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return
+	}
+
+	invitation, err := models.AcceptInvitation(token, user)
+	if err != nil {
+		c.sendInvitationError("Failed to accept invitation", err)
+		return
+	}
+	audit(c.Ctx, models.AuditOrgInvitationAccepted, models.AuditSuccess, "organization", invitation.OrganizationID, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"role":          invitation.Role,
+	})
+
+	member, err := models.GetMembership(invitation.OrganizationID, user.ID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to get membership", nil, err)
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "Invitation accepted", member, nil)
+}
+
+// DeclineInvitation turns down an invitation. It works without signing in
+// so that people without an account can decline.
+func (c *OrganizationController) DeclineInvitation() {
+	token, ok := c.invitationToken()
+	if !ok {
+		return
+	}
+
+	invitation, err := models.DeclineInvitation(token)
+	if err != nil {
+		c.sendInvitationError("Failed to decline invitation", err)
+		return
+	}
+	audit(c.Ctx, models.AuditOrgInvitationDeclined, models.AuditSuccess, "organization", invitation.OrganizationID, map[string]interface{}{
+		"invitation_id": invitation.ID,
+	})
+
+	utils.SendResponse(&c.Controller, true, "Invitation declined", nil, nil)
+}
+
+// ListUploads returns a page of the uploads of the organization's members
+func (c *OrganizationController) ListUploads() {
+	member, ok := c.membership(models.OrgTeacher)
+	if !ok {
+		return
+	}
+	page, pageSize := c.pageParams()
+
+	uploads, totalItems, err := models.ListOrganizationUploads(member.OrganizationID, page, pageSize)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list uploads", nil, err)
+		return
+	}
+	c.sendPage(page, pageSize, totalItems, uploads)
+}
+
+// ListJobs returns a page of the jobs posted by the organization's members
+// or on its behalf
+func (c *OrganizationController) ListJobs() {
+	member, ok := c.membership(models.OrgTeacher)
+	if !ok {
+		return
+	}
+	page, pageSize := c.pageParams()
+
+	jobs, totalItems, err := models.ListOrganizationJobs(member.OrganizationID, page, pageSize)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to list jobs", nil, err)
+		return
+	}
+	c.sendPage(page, pageSize, totalItems, jobs)
+}
+
+// userID returns the signed-in caller's ID
+func (c *OrganizationController) userID() string {
+	return auth.UserID(c.Ctx)
+}
+
+// membership loads the caller's membership of the organization named by the
+// :oid parameter and checks that their role is at least min. It responds
+// with 404 to non-members, so that they can't tell which organizations
+// exist, and with 403 if the role is too low.
+func (c *OrganizationController) membership(min models.OrgRole) (*models.OrganizationMember, bool) {
+	orgID := strings.Trim(c.Ctx.Input.Param(":oid"), "{}")
+	member, err := models.GetMembership(orgID, c.userID())
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Organization not found", nil, nil)
+		return nil, false
+	}
+	if !member.Role.AtLeast(min) {
+		c.forbidden("Your role in this organization does not allow this")
+		return nil, false
+	}
+	return member, true
+}
+
+// targetMember loads the member named by the :uid parameter, responding
+// with 404 if there is none
+func (c *OrganizationController) targetMember(orgID string) (*models.OrganizationMember, bool) {
+	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
+	member, err := models.GetMembership(orgID, uid)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Member not found", nil, nil)
+		return nil, false
+	}
+	return member, true
+}
+
+// organizationName reads and validates the name from the request body
+func (c *OrganizationController) organizationName() (string, bool) {
+	var req OrganizationRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+		c.badRequest("Invalid request body", err)
+		return "", false
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		c.badRequest("name is required and must be at most 255 characters", nil)
+		return "", false
+	}
+	return name, true
+}
+
+// invitationToken reads the invitation token from the request body
+func (c *OrganizationController) invitationToken() (string, bool) {
+	var req InvitationResponseRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || req.Token == "" {
+		c.badRequest("token is required", err)
+		return "", false
+	}
+	return req.Token, true
+}
+
+// pageParams reads the page and page_size query parameters
+func (c *OrganizationController) pageParams() (int, int) {
+	page, _ := c.GetInt("page", 1)
+	pageSize, _ := c.GetInt("page_size", 20)
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
+
+func (c *OrganizationController) sendPage(page, pageSize int, totalItems int64, items interface{}) {
+	pagination := map[string]interface{}{
+		"current_page": page,
+		"total_pages":  (totalItems + int64(pageSize) - 1) / int64(pageSize),
+		"page_size":    pageSize,
+		"total_items":  totalItems,
+		"items":        items,
+	}
+	utils.SendResponse(&c.Controller, true, "", pagination, nil)
+}
+
+// sendMembershipError responds to a failed membership change or invitation
+func (c *OrganizationController) sendMembershipError(message string, err error) {
+	switch {
+	case errors.Is(err, models.ErrLastOwner), errors.Is(err, models.ErrAlreadyMember):
+		c.Ctx.Output.SetStatus(409)
+	case errors.Is(err, orm.ErrNoRows):
+		c.Ctx.Output.SetStatus(404)
+	}
+	utils.SendResponse(&c.Controller, false, message, nil, err)
+}
+
+// sendInvitationError responds to a failed accept or decline
+func (c *OrganizationController) sendInvitationError(message string, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidOneTimeToken):
+		c.Ctx.Output.SetStatus(400)
+	case errors.Is(err, models.ErrInvitationEmailMismatch):
+		c.Ctx.Output.SetStatus(403)
+	}
+	utils.SendResponse(&c.Controller, false, message, nil, err)
+}
+
+func (c *OrganizationController) badRequest(message string, err error) {
+	c.Ctx.Output.SetStatus(400)
+	utils.SendResponse(&c.Controller, false, message, nil, err)
+}
+
+func (c *OrganizationController) forbidden(message string) {
+	c.Ctx.Output.SetStatus(403)
+	utils.SendResponse(&c.Controller, false, message, nil, nil)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/mailer"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	beego "github.com/beego/beego/v2/server/web"
+)
+
+// ExportMyData downloads everything held about the signed-in user as a ZIP
+// of JSON files and uploaded files, described by manifest.json
+func (c *UserController) ExportMyData() {
+	userID := auth.UserID(c.Ctx)
+	data, err := models.CollectPersonalData(userID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to collect your data", nil, err)
+		return
+	}
+
+	w := c.Ctx.ResponseWriter
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="cbc-exams-data-%s.zip"`, time.Now().UTC().Format("20060102")))
+
+	err = models.WritePersonalDataArchive(w, userID, data)
+	audit(c.Ctx, models.AuditDataExported, models.AuditResult(err), "user", userID, nil)
+	if err != nil {
+		// Headers are already sent, so the truncated file is all we can do
+		fmt.Printf("Personal data export failed: %v\n", err)
+	}
+}
+
+// CancelErasure keeps the signed-in user's account after they asked for it
+// to be deleted
+func (c *UserController) CancelErasure() {
+	userID := auth.UserID(c.Ctx)
+	cancelErasure(&c.Controller, userID)
+}
+
+// CancelErasure keeps a user's account that was scheduled for erasure
+func (c *AdminController) CancelErasure() {
+	user, ok := c.targetUser()
+	if !ok {
+		return
+	}
+	cancelErasure(&c.Controller, user.ID)
+}
+
+// cancelErasure cancels the user's pending erasure and sends the response
+func cancelErasure(c *beego.Controller, userID string) {
+	err := models.CancelUserErasure(userID)
+	audit(c.Ctx, models.AuditErasureCancelled, models.AuditResult(err), "user", userID, nil)
+	if err != nil {
+		if errors.Is(err, models.ErrErasureNotScheduled) {
+			c.Ctx.Output.SetStatus(409)
+		}
+		utils.SendResponse(c, false, "Failed to cancel account deletion", nil, err)
+		return
+	}
+	utils.SendResponse(c, true, "Account deletion cancelled", nil, nil)
+}
+
+// scheduleErasure schedules the user's account for erasure after the grace
+// period, emails them about it and sends the response
+func scheduleErasure(c *beego.Controller, userID string) {
+	user, err := models.ScheduleUserErasure(userID, time.Now().Add(config.ErasureGracePeriod))
+	audit(c.Ctx, models.AuditErasureScheduled, models.AuditResult(err), "user", userID, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastAdmin), errors.Is(err, models.ErrNoOrgSuccessor):
+			c.Ctx.Output.SetStatus(409)
+		case errors.Is(err, orm.ErrNoRows):
+			c.Ctx.Output.SetStatus(404)
+		}
+		utils.SendResponse(c, false, "Failed to delete user", nil, err)
+		return
+	}
+
+	// Tell the owner, so that a deletion they didn't ask for can be undone
+	err = mailer.SendTemplate(user.Email, "erasure_scheduled", map[string]interface{}{
+		"Username":   user.Username,
+		"ErasureAt":  user.ErasureScheduledAt.UTC().Format("2 January 2006 15:04 MST"),
+		"AccountURL": config.FrontendURL + "/account",
+	})
+	if err != nil {
+		fmt.Printf("Failed to send erasure notice: %v\n", err)
+	}
+
+	utils.SendResponse(c, true, "Account scheduled for deletion", map[string]interface{}{
+		"erasure_scheduled_at": user.ErasureScheduledAt,
+	}, nil)
+}
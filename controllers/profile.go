@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// GetMe returns the signed-in user's full profile
+func (c *UserController) GetMe() {
+	userID := auth.UserID(c.Ctx)
+	c.sendProfile(userID)
+}
+
+// GetProfile returns a user's profile. Other users only see public fields.
+func (c *UserController) GetProfile() {
+	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
+	c.sendProfile(uid)
+}
+
+// UpdateMe updates fields of the signed-in user's profile. Only the fields
+// present in the body change; fields the user's role may not edit are
+// rejected.
+func (c *UserController) UpdateMe() {
+	var update models.ProfileUpdate
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &update); err != nil {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Invalid request body", nil, err)
+		return
+	}
+
+	userID := auth.UserID(c.Ctx)
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return
+	}
+
+	profile, err := models.GetUserProfile(user.ID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to load profile", nil, err)
+		return
+	}
+
+	if err := update.Apply(profile, user.Role); err != nil {
+		var fieldErr *models.ProfileFieldError
+		if errors.As(err, &fieldErr) {
+			if len(fieldErr.Invalid) == 0 {
+				c.Ctx.Output.SetStatus(403)
+			} else {
+				c.Ctx.Output.SetStatus(400)
+			}
+			utils.SendResponse(&c.Controller, false, "Profile update rejected", fieldErr, nil)
+			return
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to update profile", nil, err)
+		return
+	}
+
+	if err := models.SaveUserProfile(profile); err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to update profile", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Profile updated", models.ProfileView(user, profile, user.ID, user.Role), nil)
+}
+
+// sendProfile responds with the user's profile as the caller may see it
+func (c *UserController) sendProfile(uid string) {
+	user, err := models.GetUserByID(uid)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "User not found", nil, nil)
+		return
+	}
+
+	profile, err := models.GetUserProfile(user.ID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to load profile", nil, err)
+		return
+	}
+
+	var viewerID string
+	var viewerRole models.Role
+	if viewer, ok := auth.FromContext(c.Ctx); ok {
+		viewerID, viewerRole = viewer.ID, viewer.Role
+	}
+	utils.SendResponse(&c.Controller, true, "", models.ProfileView(user, profile, viewerID, viewerRole), nil)
+}
@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"cbc-backend/auth"
 	"cbc-backend/models"
 	"cbc-backend/utils"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -28,95 +30,170 @@ func (c *ResourceController) BadRequest(msg string, err error) {
 	utils.SendResponse(&c.Controller, false, msg, nil, err)
 }
 
-// GetUserID gets the user ID from JWT token
-func (c *ResourceController) GetUserID() string {
-	authHeader := c.Ctx.Input.Header("Authorization")
-	if authHeader == "" {
-		return ""
-	}
-
-	// Remove "Bearer " prefix
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Get user ID from token
-	userID, err := utils.GetUserIDFromToken(tokenString)
-	if err != nil {
-		fmt.Printf("Error getting user ID from token: %v\n", err)
-		return ""
-	}
-
-	return userID
-}
-
-// Get retrieves a list of resources with pagination
+// Get retrieves a list of resources with pagination. name is a full-text
+// query over the name, folder and path of resources; matches come best
+// first, with highlighted snippets. categories may be repeated, matching
+// resources with all of them, or any of them with category_mode=or. grade,
+// subject, term, year, exam_type and marking_scheme filter by the metadata
+// extracted from names and paths. The response has facet counts by category
+// and top-level directory.
 func (r *ResourceController) Get() {
-	// Get all query parameters
 	page, _ := r.GetInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
 	pageSize := 20 // Fixed page size
 
-	// Create params map with all search parameters
-	params := map[string]string{
-		"name":       r.GetString("name"),
-		"categories": r.GetString("categories"),
+	search := models.ResourceSearch{
+		Query:     strings.TrimSpace(r.GetString("name")),
+		Directory: r.GetString("directory"),
 	}
-
-	// Log the received parameters for debugging
-	fmt.Printf("Received search parameters: %+v\n", params)
-
-	// Fetch resources with pagination
-	o := orm.NewOrm()
-	var resources []*models.Resource
-
-	// Calculate offset
-	offset := (page - 1) * pageSize
-
-	// Build query
-	qs := o.QueryTable("web_crawler_resources")
-
-	// Apply filters
-	if name, ok := params["name"]; ok && name != "" {
-		qs = qs.Filter("name__icontains", name)
+	for _, category := range r.GetStrings("categories") {
+		if category != "" {
+			search.Categories = append(search.Categories, category)
+		}
 	}
-
-	if categories, ok := params["categories"]; ok && categories != "" {
-		// Format the category for PostgreSQL array contains query
-		// This will match if the categories array contains the specified category
-		qs = qs.Filter("categories__contains", fmt.Sprintf("{%s}", categories))
+	switch r.GetString("category_mode", "and") {
+	case "and":
+	case "or":
+		search.AnyCategory = true
+	default:
+		r.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&r.Controller, false, `category_mode must be "and" or "or"`, nil, nil)
+		return
 	}
-
-	// Get total count
-	totalItems, err := qs.Count()
-	if err != nil {
-		utils.SendResponse(&r.Controller, false, "Failed to get total count", nil, err)
+	if msg := r.parseMetadataFilters(&search); msg != "" {
+		r.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&r.Controller, false, msg, nil, nil)
 		return
 	}
 
-	// Calculate total pages
-	totalPages := (totalItems + int64(pageSize) - 1) / int64(pageSize)
-
-	// Get paginated results
-	_, err = qs.OrderBy("-created_at").Limit(pageSize).Offset(offset).All(&resources)
+	resources, totalItems, err := models.SearchResources(search, page, pageSize)
 	if err != nil {
 		utils.SendResponse(&r.Controller, false, "Failed to fetch resources", nil, err)
 		return
 	}
+	facets, err := models.SearchResourceFacets(search)
+	if err != nil {
+		utils.SendResponse(&r.Controller, false, "Failed to count resources", nil, err)
+		return
+	}
 
 	// Create pagination response with fields in the desired order
 	pagination := map[string]interface{}{
 		"current_page": page,
-		"total_pages":  totalPages,
+		"total_pages":  (totalItems + int64(pageSize) - 1) / int64(pageSize),
 		"page_size":    pageSize,
 		"total_items":  totalItems,
+		"facets":       facets,
 		"items":        resources, // items last
 	}
 
 	utils.SendResponse(&r.Controller, true, "", pagination, nil)
 }
 
+// parseMetadataFilters reads the metadata filters of a resource listing into
+// the search. Grades, subjects and exam types may be written any way the
+// extractor understands, e.g. grade=g7 or subject=maths. It returns an error
+// message for invalid filters.
+func (r *ResourceController) parseMetadataFilters(search *models.ResourceSearch) string {
+	if grade := r.GetString("grade"); grade != "" {
+		if search.Grade = models.NormalizeGrade(grade); search.Grade == "" {
+			return fmt.Sprintf("Unknown grade %q", grade)
+		}
+	}
+	if subject := r.GetString("subject"); subject != "" {
+		if search.Subject = models.NormalizeSubject(subject); search.Subject == "" {
+			return fmt.Sprintf("Unknown subject %q", subject)
+		}
+	}
+	if examType := r.GetString("exam_type"); examType != "" {
+		if search.ExamType = models.NormalizeExamType(examType); search.ExamType == "" {
+			return fmt.Sprintf("Unknown exam type %q", examType)
+		}
+	}
+	term, err := r.GetInt("term", 0)
+	if err != nil || term < 0 || term > 3 {
+		return "term must be 1, 2 or 3"
+	}
+	search.Term = term
+	year, err := r.GetInt("year", 0)
+	if err != nil || year < 0 {
+		return "year must be a year such as 2024"
+	}
+	search.Year = year
+	switch r.GetString("marking_scheme") {
+	case "":
+	case "true":
+		markingScheme := true
+		search.MarkingScheme = &markingScheme
+	case "false":
+		markingScheme := false
+		search.MarkingScheme = &markingScheme
+	default:
+		return "marking_scheme must be true or false"
+	}
+	return ""
+}
+
+// GetOne retrieves a resource by ID with its categories parsed, its download
+// count and the breadcrumbs of the folders containing it
+func (c *ResourceController) GetOne() {
+	resource, ok := c.findResource()
+	if !ok {
+		return
+	}
+
+	detail, err := models.GetResourceDetail(resource.Id)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to load resource", nil, err)
+		return
+	}
+	utils.SendResponse(&c.Controller, true, "", detail, nil)
+}
+
+// Download counts a download of the resource and redirects to its file
+func (c *ResourceController) Download() {
+	resource, ok := c.findResource()
+	if !ok {
+		return
+	}
+	if resource.GoogleDriveDownloadLink == "" {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "This resource has no download link", nil, nil)
+		return
+	}
+
+	if err := models.RecordResourceDownload(resource.Id); err != nil {
+		// Don't keep the user from their file over a lost count
+		fmt.Printf("Warning: Failed to count download of resource %s: %v\n", resource.Id, err)
+	}
+	c.Redirect(resource.GoogleDriveDownloadLink, 302)
+}
+
+// findResource loads the resource named by the :id parameter, responding
+// with 404 if there is none
+func (c *ResourceController) findResource() (*models.Resource, bool) {
+	id, err := uuid.Parse(c.Ctx.Input.Param(":id"))
+	if err == nil {
+		resource, err := models.GetResource(id.String())
+		if err == nil {
+			return resource, true
+		}
+		if !errors.Is(err, orm.ErrNoRows) {
+			utils.SendResponse(&c.Controller, false, "Failed to load resource", nil, err)
+			return nil, false
+		}
+	}
+
+	c.Ctx.Output.SetStatus(404)
+	utils.SendResponse(&c.Controller, false, "Resource not found", nil, nil)
+	return nil, false
+}
+
 // Post handles file upload
 func (c *ResourceController) Post() {
-	// Get user ID from token
-	userID := c.GetUserID()
+	userID := auth.UserID(c.Ctx)
 	if userID == "" {
 		utils.SendResponse(&c.Controller, false, "Invalid or missing authentication", nil, nil)
 		return
@@ -195,6 +272,10 @@ func (c *ResourceController) Post() {
 		utils.SendResponse(&c.Controller, false, "Failed to create upload record", nil, err)
 		return
 	}
+	audit(c.Ctx, models.AuditResourceUploaded, models.AuditSuccess, "upload", uploadId, map[string]interface{}{
+		"file_name": header.Filename,
+		"file_size": fileInfo.Size(),
+	})
 
 	c.Data["json"] = map[string]interface{}{
 		"success": true,
@@ -209,6 +290,36 @@ func (c *ResourceController) Post() {
 	c.ServeJSON()
 }
 
+// DeleteUpload moves an upload to the trash. Users may delete their own
+// uploads and admins any upload.
+func (c *ResourceController) DeleteUpload() {
+	id := strings.Trim(c.Ctx.Input.Param(":id"), "{}")
+	upload, err := models.GetUpload(id)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "Upload not found", nil, nil)
+		return
+	}
+
+	principal, _ := auth.FromContext(c.Ctx)
+	if upload.UserID != principal.ID && !principal.IsAdmin() {
+		c.Ctx.Output.SetStatus(403)
+		utils.SendResponse(&c.Controller, false, "Unauthorized to delete this upload", nil, nil)
+		return
+	}
+
+	err = models.DeleteUpload(id)
+	audit(c.Ctx, models.AuditUploadDeleted, models.AuditResult(err), "upload", id, map[string]interface{}{
+		"file_name": upload.FileName,
+	})
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to delete upload", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Upload deleted successfully", nil, nil)
+}
+
 // validateFileType checks if the file extension is allowed
 func validateFileType(filename string) error {
 	ext := strings.ToLower(path.Ext(filename))
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"errors"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ListTrash returns a page of deleted users, jobs and uploads, most recently
+// deleted first. Filter by kind with type=user, job or upload.
+func (c *AdminController) ListTrash() {
+	page, _ := c.GetInt("page", 1)
+	pageSize, _ := c.GetInt("page_size", 20)
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	items, totalItems, err := models.ListTrash(c.GetString("type"), page, pageSize)
+	if err != nil {
+		if errors.Is(err, models.ErrUnknownTrashKind) {
+			c.badRequest("Invalid type", err)
+			return
+		}
+		utils.SendResponse(&c.Controller, false, "Failed to list the trash", nil, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		result[i] = map[string]interface{}{
+			"type":       item.Type,
+			"id":         item.ID,
+			"name":       item.Name,
+			"deleted_at": item.DeletedAt,
+			"purge_at":   item.DeletedAt.Add(config.TrashRetention),
+		}
+	}
+
+	pagination := map[string]interface{}{
+		"current_page": page,
+		"total_pages":  (totalItems + int64(pageSize) - 1) / int64(pageSize),
+		"page_size":    pageSize,
+		"total_items":  totalItems,
+		"items":        result,
+	}
+	utils.SendResponse(&c.Controller, true, "", pagination, nil)
+}
+
+// RestoreFromTrash undeletes a user, job or upload
+func (c *AdminController) RestoreFromTrash() {
+	kind := c.Ctx.Input.Param(":type")
+	id := strings.Trim(c.Ctx.Input.Param(":id"), "{}")
+
+	err := models.RestoreFromTrash(kind, id)
+	audit(c.Ctx, models.AuditRestored, models.AuditResult(err), kind, id, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnknownTrashKind):
+			c.badRequest("Invalid type", err)
+		case err == orm.ErrNoRows:
+			c.Ctx.Output.SetStatus(404)
+			utils.SendResponse(&c.Controller, false, "Item not found in the trash", nil, nil)
+		default:
+			utils.SendResponse(&c.Controller, false, "Failed to restore item", nil, err)
+		}
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Item restored", nil, nil)
+}
@@ -1,14 +1,26 @@
 package controllers
 
 import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/mailer"
 	"cbc-backend/models"
 	"cbc-backend/utils"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/beego/beego/v2/client/orm"
 	beego "github.com/beego/beego/v2/server/web"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -30,6 +42,11 @@ type SignupRequest struct {
 	Email    string `json:"email"`
 }
 
+// RefreshRequest represents the token refresh and logout request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // PromoteRequest represents the admin promotion request body
 type PromoteRequest struct {
 	SecretKey string `json:"secret_key"` // Additional security measure
@@ -53,8 +70,14 @@ func (c *UserController) Post() {
 		return
 	}
 
+	// Enforce the password policy
+	if err := utils.CheckPasswordPolicy(req.Password, req.Username, req.Email); err != nil {
+		sendPasswordPolicyError(&c.Controller, err)
+		return
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := models.HashPassword(req.Password)
 	if err != nil {
 		utils.SendResponse(&c.Controller, false, "Failed to hash password", nil, err)
 		return
@@ -63,9 +86,9 @@ func (c *UserController) Post() {
 	// Create user with default role
 	user := &models.User{
 		Username: req.Username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		Email:    req.Email,
-		Role:     "user", // Default role - cannot be overridden from request
+		Role:     models.DefaultRole, // Cannot be overridden from request
 	}
 
 	if err := models.CreateUser(user); err != nil {
@@ -73,7 +96,72 @@ func (c *UserController) Post() {
 		return
 	}
 
-	utils.SendResponse(&c.Controller, true, "User created successfully", nil, nil)
+	// The account works right away, with some features held back until the
+	// email address is confirmed
+	if err := sendVerificationEmail(user); err != nil {
+		fmt.Printf("Failed to send verification email: %v\n", err)
+	}
+
+	utils.SendResponse(&c.Controller, true, "User created successfully. Check your email to verify your address.", nil, nil)
+}
+
+// Verify confirms the user's email address with the token from the
+// verification email
+func (c *UserController) Verify() {
+	token := c.GetString("token")
+	if token == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "token is required", nil, nil)
+		return
+	}
+
+	user, err := models.VerifyEmail(token)
+	if err != nil {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "Failed to verify email", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Email verified successfully", map[string]interface{}{
+		"user_id":     user.ID,
+		"verified_at": user.VerifiedAt,
+	}, nil)
+}
+
+// ResendVerification sends a new verification email to the signed-in user
+func (c *UserController) ResendVerification() {
+	userID := auth.UserID(c.Ctx)
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "User not found", nil, err)
+		return
+	}
+
+	if user.VerifiedAt != nil {
+		utils.SendResponse(&c.Controller, false, "Email is already verified", nil, nil)
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to send verification email", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Verification email sent", nil, nil)
+}
+
+// sendVerificationEmail creates a verification token and emails the link
+func sendVerificationEmail(user *models.User) error {
+	token, err := models.CreateEmailVerification(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(user.Email, "verify_email", map[string]interface{}{
+		"Username":  user.Username,
+		"VerifyURL": config.FrontendURL + "/verify-email?token=" + url.QueryEscape(token),
+		"ExpiresIn": fmt.Sprintf("%d hours", int(models.EmailVerificationTTL.Hours())),
+	})
 }
 
 // Login handles user authentication
@@ -88,49 +176,303 @@ func (c *UserController) Login() {
 		return
 	}
 
-	// Get user from database
-	user, err := models.GetUserByUsername(req.Username)
+	// Throttle repeated failures per account and per IP
+	ip := c.Ctx.Input.IP()
+	wait, err := models.LoginRetryAfter(req.Username, ip)
 	if err != nil {
-		utils.SendResponse(&c.Controller, false, "Invalid credentials", nil, err)
+		fmt.Printf("Failed to check login attempts: %v\n", err)
+	}
+	if wait > 0 {
+		auditLogin(c.Ctx, nil, req.Username, models.AuditDenied, map[string]interface{}{"reason": "throttled"})
+		c.Ctx.Output.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.Ctx.Output.SetStatus(429)
+		utils.SendResponse(&c.Controller, false, "Too many login attempts. Please try again later.", nil, nil)
 		return
 	}
 
+	// Get user from database. Unknown users still pay for a bcrypt
+	// comparison so that response times don't reveal which usernames exist.
+	user, err := models.GetUserByUsername(req.Username)
+	found := err == nil
+	passwordHash := dummyPasswordHash()
+	if found {
+		passwordHash = []byte(user.Password)
+	}
+
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		utils.SendResponse(&c.Controller, false, "Invalid credentials", nil, err)
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(req.Password)); err != nil || !found {
+		if err := models.RecordLoginAttempt(req.Username, ip, false); err != nil {
+			fmt.Printf("Failed to record login attempt: %v\n", err)
+		}
+		if !found {
+			user = nil
+		}
+		auditLogin(c.Ctx, user, req.Username, models.AuditFailure, map[string]interface{}{"reason": "invalid_credentials"})
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid username or password", nil, nil)
+		return
+	}
+
+	// The success is recorded by completeLogin, once every factor has
+	// passed. Until then the login counts as neither success nor failure.
+
+	// An admin may have required a new password
+	if user.PasswordResetRequired {
+		auditLogin(c.Ctx, user, "", models.AuditDenied, map[string]interface{}{"reason": "password_reset_required"})
+		c.Ctx.Output.SetStatus(403)
+		utils.SendResponse(&c.Controller, false, "You must reset your password before signing in. Use the link in your email or request a new one.", map[string]interface{}{
+			"password_reset_required": true,
+		}, nil)
 		return
 	}
 
-	// Generate JWT token with role
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.Role)
+	c.signIn(user, "password")
+}
+
+// signIn finishes a login once the user has proven their identity. Users
+// with two-factor authentication get an mfa_token to finish signing in at
+// /login/mfa instead of tokens. method names how they proved it, for the
+// audit log.
+func (c *UserController) signIn(user *models.User, method string) {
+	if !c.checkNotSuspended(user, method) {
+		return
+	}
+
+	mfaEnabled, err := models.IsMFAEnabled(user.ID)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to check two-factor authentication", nil, err)
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := utils.GenerateMFAPendingToken(user.ID, user.Username)
+		if err != nil {
+			utils.SendResponse(&c.Controller, false, "Failed to generate token", nil, err)
+			return
+		}
+		utils.SendResponse(&c.Controller, true, "Two-factor authentication code required", map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(config.MFAPendingTTL.Seconds()),
+		}, nil)
+		return
+	}
+
+	c.completeLogin(user, method, false)
+}
+
+// checkNotSuspended responds with 403 and returns false if the user is
+// suspended
+func (c *UserController) checkNotSuspended(user *models.User, method string) bool {
+	if user.SuspendedAt == nil {
+		return true
+	}
+	auditLogin(c.Ctx, user, "", models.AuditDenied, map[string]interface{}{"method": method, "reason": "suspended"})
+	c.Ctx.Output.SetStatus(403)
+	utils.SendResponse(&c.Controller, false, "This account has been suspended. Please contact support.", nil, nil)
+	return false
+}
+
+// completeLogin issues tokens and a session cookie for an authenticated user
+// and sends the login response. mfa records whether the login included a
+// second factor.
+func (c *UserController) completeLogin(user *models.User, method string, mfa bool) {
+	// Every factor has passed, so the account's failed attempts stop counting
+	if err := models.RecordLoginAttempt(user.Username, c.Ctx.Input.IP(), true); err != nil {
+		fmt.Printf("Failed to record login attempt: %v\n", err)
+	}
+
+	// Every login starts a new refresh token family, which identifies the
+	// device in the user's list of signed-in devices
+	familyID := uuid.New().String()
+	if _, err := models.CreateDevice(familyID, user.ID, c.Ctx.Input.UserAgent(), c.Ctx.Input.IP()); err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to record device", nil, err)
+		return
+	}
+
+	tokens, err := issueTokens(user, familyID, mfa)
 	if err != nil {
 		utils.SendResponse(&c.Controller, false, "Failed to generate token", nil, err)
 		return
 	}
 
-	// Return both token and user ID in response
-	utils.SendResponse(&c.Controller, true, "Login successful", map[string]interface{}{
-		"token":    token,
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
+	// Browser clients authenticate with an HttpOnly session cookie instead
+	sessionID, session, err := models.CreateSession(user.ID, familyID, mfa)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to create session", nil, err)
+		return
+	}
+	setSessionCookies(c.Ctx.ResponseWriter, sessionID, session.CsrfToken)
+
+	// Return both tokens and user ID in response
+	tokens["csrf_token"] = session.CsrfToken
+	tokens["user_id"] = user.ID
+	tokens["username"] = user.Username
+	tokens["role"] = user.Role
+	tokens["email_verified"] = user.VerifiedAt != nil
+	tokens["mfa"] = mfa
+	auditLogin(c.Ctx, user, "", models.AuditSuccess, map[string]interface{}{"method": method, "mfa": mfa})
+	utils.SendResponse(&c.Controller, true, "Login successful", tokens, nil)
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash returns a hash, at the configured cost, to compare
+// against when the username doesn't exist
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, _ := models.HashPassword("cbc-dummy-password")
+		dummyHash = []byte(hash)
+	})
+	return dummyHash
+}
+
+// sendPasswordPolicyError responds with the list of password policy violations
+func sendPasswordPolicyError(c *beego.Controller, err error) {
+	var policyErr *utils.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		utils.SendResponse(c, false, "Invalid password", nil, err)
+		return
+	}
+	c.Ctx.Output.SetStatus(400)
+	utils.SendResponse(c, false, "Password does not meet the requirements", policyErr, nil)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair
+func (c *UserController) Refresh() {
+	var req RefreshRequest
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil || req.RefreshToken == "" {
+		c.Ctx.Output.SetStatus(400)
+		utils.SendResponse(&c.Controller, false, "refresh_token is required", nil, err)
+		return
+	}
+
+	rotated, refreshToken, err := models.RotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid refresh token", nil, err)
+		return
+	}
+
+	// Pick up role changes and suspensions made since the last refresh
+	user, err := models.GetUserByID(rotated.UserID)
+	if err != nil || user.SuspendedAt != nil {
+		c.Ctx.Output.SetStatus(401)
+		utils.SendResponse(&c.Controller, false, "Invalid refresh token", nil, nil)
+		return
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Username, string(user.Role), rotated.FamilyID, rotated.MFA)
+	if err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to generate token", nil, err)
+		return
+	}
+
+	utils.SendResponse(&c.Controller, true, "Token refreshed", map[string]interface{}{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(config.AccessTokenTTL.Seconds()),
 	}, nil)
 }
 
-// Logout handles user logout
+// Logout signs the current device out, ending the cookie session, if any,
+// and revoking the current access token and its refresh token family
 func (c *UserController) Logout() {
-	// Get the token from Authorization header
-	authHeader := c.Ctx.Input.Header("Authorization")
-	if authHeader == "" {
-		utils.SendResponse(&c.Controller, false, "No token provided", nil, nil)
+	// Cookie-authenticated clients only need their session removed
+	if sessionID := c.Ctx.GetCookie(config.SessionCookieName); sessionID != "" {
+		if err := models.DeleteSession(sessionID); err != nil {
+			fmt.Printf("Warning: Failed to delete session: %v\n", err)
+		}
+		clearSessionCookies(c.Ctx.ResponseWriter)
+	}
+
+	principal, ok := auth.FromContext(c.Ctx)
+	if !ok {
+		utils.SendResponse(&c.Controller, true, "Logged out successfully", nil, nil)
+		return
+	}
+
+	if principal.DeviceID != "" {
+		if err := models.SignOutDevice(principal.ID, principal.DeviceID); err != nil && !errors.Is(err, orm.ErrNoRows) {
+			utils.SendResponse(&c.Controller, false, "Failed to sign out device", nil, err)
+			return
+		}
+	}
+
+	// Bearer clients also revoke the access token they authenticated with
+	if principal.Method != auth.MethodJWT {
+		utils.SendResponse(&c.Controller, true, "Logged out successfully", nil, nil)
+		return
+	}
+
+	if err := models.RevokeAccessToken(principal.TokenID, principal.ExpiresAt); err != nil {
+		utils.SendResponse(&c.Controller, false, "Failed to revoke token", nil, err)
 		return
 	}
 
-	// For now, we'll just return success since JWT tokens are stateless
 	utils.SendResponse(&c.Controller, true, "Logged out successfully", nil, nil)
 }
 
-// ForgotPassword initiates password reset
+// setSessionCookies sets the HttpOnly session cookie and the CSRF cookie that
+// the frontend reads and echoes back in the X-CSRF-Token header
+func setSessionCookies(w http.ResponseWriter, sessionID, csrfToken string) {
+	maxAge := int(config.SessionMaxAge.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     config.SessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     config.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookies expires both session cookies
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{config.SessionCookieName, config.CSRFCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   config.CookieSecure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// issueTokens creates an access token and a refresh token in the given family
+func issueTokens(user *models.User, familyID string, mfa bool) (map[string]interface{}, error) {
+	token, err := utils.GenerateJWT(user.ID, user.Username, string(user.Role), familyID, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := models.IssueRefreshToken(user.ID, familyID, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(config.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// ForgotPassword initiates password reset by emailing a reset link. The
+// response is the same whether or not the email belongs to an account.
 func (c *UserController) ForgotPassword() {
 	var request struct {
 		Email string `json:"email"`
@@ -141,18 +483,31 @@ func (c *UserController) ForgotPassword() {
 		return
 	}
 
-	// Generate reset token
-	resetToken, err := models.CreatePasswordReset(request.Email)
+	user, err := sendPasswordResetEmail(request.Email)
 	if err != nil {
-		utils.SendResponse(&c.Controller, false, "Failed to create reset token", nil, err)
-		return
+		fmt.Printf("Password reset not sent: %v\n", err)
+	}
+	if user != nil {
+		audit(c.Ctx, models.AuditPasswordResetRequested, models.AuditResult(err), "user", user.ID, nil)
 	}
 
-	// TODO: Send email with reset link
-	// For now, return token in response (in production, send via email)
-	utils.SendResponse(&c.Controller, true, "Reset token generated", map[string]string{
-		"reset_token": resetToken,
-	}, nil)
+	utils.SendResponse(&c.Controller, true, "If an account exists for that email, a reset link has been sent", nil, nil)
+}
+
+// sendPasswordResetEmail creates a reset token for the account with the
+// email address and emails the reset link. It returns the account, if one
+// was found, even when sending fails.
+func sendPasswordResetEmail(email string) (*models.User, error) {
+	user, resetToken, err := models.CreatePasswordReset(email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, mailer.SendTemplate(user.Email, "password_reset", map[string]interface{}{
+		"Username":  user.Username,
+		"ResetURL":  config.FrontendURL + "/reset-password?token=" + url.QueryEscape(resetToken),
+		"ExpiresIn": fmt.Sprintf("%d hours", int(models.PasswordResetTTL.Hours())),
+	})
 }
 
 // ResetPassword handles the password reset
@@ -167,7 +522,16 @@ func (c *UserController) ResetPassword() {
 		return
 	}
 
-	if err := models.ResetPassword(request.ResetToken, request.NewPassword); err != nil {
+	user, err := models.ResetPassword(request.ResetToken, request.NewPassword)
+	if user != nil {
+		audit(c.Ctx, models.AuditPasswordReset, models.AuditResult(err), "user", user.ID, nil)
+	}
+	if err != nil {
+		var policyErr *utils.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			sendPasswordPolicyError(&c.Controller, err)
+			return
+		}
 		utils.SendResponse(&c.Controller, false, "Failed to reset password", nil, err)
 		return
 	}
@@ -175,7 +539,8 @@ func (c *UserController) ResetPassword() {
 	utils.SendResponse(&c.Controller, true, "Password reset successfully", nil, nil)
 }
 
-// Delete handles user deletion
+// Delete schedules a user's account for erasure. Users may delete their own
+// account and admins any account.
 func (c *UserController) Delete() {
 	// Get user ID from URL - remove curly braces if present
 	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
@@ -184,38 +549,24 @@ func (c *UserController) Delete() {
 		return
 	}
 
-	// Get claims from JWT token
-	claims, err := utils.GetJWTClaims(c.Ctx)
-	if err != nil {
-		utils.SendResponse(&c.Controller, false, "Invalid token", nil, err)
-		return
-	}
-
-	// Get user ID directly as string
-	tokenUserID := claims["user_id"].(string)
-
-	// Safely get role from claims
-	var userRole string
-	if role, ok := claims["role"]; ok && role != nil {
-		userRole = role.(string)
-	}
-
 	// Only allow users to delete their own account or admin users
-	if tokenUserID != uid && userRole != "admin" {
-		utils.SendResponse(&c.Controller, false, "Unauthorized to delete this user", nil, nil)
+	principal, ok := auth.FromContext(c.Ctx)
+	if !ok {
+		utils.SendResponse(&c.Controller, false, "Invalid token", nil, nil)
 		return
 	}
-
-	// Delete user
-	if err := models.DeleteUserByID(uid); err != nil {
-		utils.SendResponse(&c.Controller, false, "Failed to delete user", nil, err)
+	if principal.ID != uid && !principal.IsAdmin() {
+		c.Ctx.Output.SetStatus(403)
+		utils.SendResponse(&c.Controller, false, "Unauthorized to delete this user", nil, nil)
 		return
 	}
 
-	utils.SendResponse(&c.Controller, true, "User deleted successfully", nil, nil)
+	// The account and its data are erased after a grace period
+	scheduleErasure(&c.Controller, uid)
 }
 
-// PromoteToAdmin promotes a user to admin role
+// PromoteToAdmin promotes a user to admin role. Only existing admins may
+// call it (see the admin routes), and only with ADMIN_PROMOTION_KEY.
 func (c *UserController) PromoteToAdmin() {
 	// Get user ID from URL
 	uid := strings.Trim(c.Ctx.Input.Param(":uid"), "{}")
@@ -224,20 +575,6 @@ func (c *UserController) PromoteToAdmin() {
 		return
 	}
 
-	// Get claims from JWT token
-	claims, err := utils.GetJWTClaims(c.Ctx)
-	if err != nil {
-		utils.SendResponse(&c.Controller, false, "Invalid token", nil, err)
-		return
-	}
-
-	// Only existing admins can promote others
-	userRole, ok := claims["role"].(string)
-	if !ok || userRole != "admin" {
-		utils.SendResponse(&c.Controller, false, "Only administrators can promote users", nil, nil)
-		return
-	}
-
 	// Verify secret key
 	var req PromoteRequest
 	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
@@ -245,17 +582,35 @@ func (c *UserController) PromoteToAdmin() {
 		return
 	}
 
-	// Verify against environment variable or config
-	if req.SecretKey != os.Getenv("ADMIN_PROMOTION_KEY") {
+	// An unset key disables promotion, so that an empty secret_key can't match it
+	promotionKey := os.Getenv("ADMIN_PROMOTION_KEY")
+	if promotionKey == "" || subtle.ConstantTimeCompare([]byte(req.SecretKey), []byte(promotionKey)) != 1 {
+		audit(c.Ctx, models.AuditRoleChanged, models.AuditDenied, "user", uid, map[string]interface{}{
+			"to":     models.RoleAdmin,
+			"reason": "invalid_promotion_key",
+		})
+		c.Ctx.Output.SetStatus(403)
 		utils.SendResponse(&c.Controller, false, "Invalid promotion key", nil, nil)
 		return
 	}
 
-	// Update user role to admin
-	if err := models.PromoteUserToAdmin(uid); err != nil {
+	target, err := models.GetUserByID(uid)
+	if err != nil {
+		c.Ctx.Output.SetStatus(404)
+		utils.SendResponse(&c.Controller, false, "User not found", nil, nil)
+		return
+	}
+
+	// Like any role change, this signs the user out everywhere
+	user, err := models.SetUserRole(target.ID, models.RoleAdmin)
+	audit(c.Ctx, models.AuditRoleChanged, models.AuditResult(err), "user", target.ID, map[string]interface{}{
+		"from": target.Role,
+		"to":   models.RoleAdmin,
+	})
+	if err != nil {
 		utils.SendResponse(&c.Controller, false, "Failed to promote user", nil, err)
 		return
 	}
 
-	utils.SendResponse(&c.Controller, true, "User promoted to admin successfully", nil, nil)
+	utils.SendResponse(&c.Controller, true, "User promoted to admin successfully", user, nil)
 }
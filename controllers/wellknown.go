@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"cbc-backend/utils"
+
+	beego "github.com/beego/beego/v2/server/web"
+)
+
+// WellKnownController serves the /.well-known documents other services use
+// to integrate with the API
+type WellKnownController struct {
+	beego.Controller
+}
+
+// JWKS publishes the public keys that verify our JWTs, including keys that
+// will start signing soon. Other services match a token's kid header to a
+// key here.
+func (c *WellKnownController) JWKS() {
+	c.Ctx.Output.Header("Cache-Control", "public, max-age=60")
+	c.Data["json"] = utils.PublicJWKS()
+	c.ServeJSON()
+}
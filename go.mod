@@ -10,6 +10,7 @@ require (
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/crypto v0.0.0-20220315160706-3147a52a75dd
+	golang.org/x/term v0.5.0
 )
 
 require (
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"cbc-backend/config"
+	"errors"
+	"fmt"
+)
+
+// Message is a single email with both HTML and plain text bodies
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the queue used by SendTemplate. It is set up by Init.
+var Default *Queue
+
+// ErrNotInitialized is returned when sending before Init has been called
+var ErrNotInitialized = errors.New("mailer not initialized")
+
+// Init creates the configured Mailer and starts the delivery queue
+func Init() error {
+	var m Mailer
+	switch config.MailDriver {
+	case "smtp":
+		m = &SMTPMailer{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.MailFrom,
+		}
+	case "outbox":
+		m = &OutboxMailer{Dir: config.MailOutboxDir}
+	default:
+		return fmt.Errorf("unknown MAIL_DRIVER %q (use smtp or outbox)", config.MailDriver)
+	}
+
+	Default = NewQueue(m, config.MailQueueSize, config.MailMaxAttempts)
+	Default.Start(config.MailWorkers)
+	return nil
+}
+
+// SendTemplate renders the named template and queues it for delivery to the
+// recipient. It returns as soon as the message is queued.
+func SendTemplate(to, name string, data interface{}) error {
+	if Default == nil {
+		return ErrNotInitialized
+	}
+
+	msg, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return Default.Enqueue(msg)
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutboxMailer writes each message to a .eml file in Dir instead of sending
+// it. It is meant for development and tests.
+type OutboxMailer struct {
+	Dir string
+}
+
+// Send writes the message to the outbox directory
+func (m *OutboxMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+
+	body, err := buildMIME("outbox@localhost", msg)
+	if err != nil {
+		return err
+	}
+
+	// Keep file names sortable and recognisable by recipient
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0644)
+}
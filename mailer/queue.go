@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Enqueue when the queue has no free capacity
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue delivers messages in the background so that a slow mail server does
+// not block requests. Failed deliveries are retried with exponential backoff.
+type Queue struct {
+	mailer      Mailer
+	messages    chan Message
+	maxAttempts int
+	backoff     time.Duration
+	wg          sync.WaitGroup
+}
+
+// NewQueue creates a queue holding up to size pending messages, each tried
+// at most maxAttempts times
+func NewQueue(m Mailer, size, maxAttempts int) *Queue {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Queue{
+		mailer:      m,
+		messages:    make(chan Message, size),
+		maxAttempts: maxAttempts,
+		backoff:     2 * time.Second,
+	}
+}
+
+// Start launches the delivery workers
+func (q *Queue) Start(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Enqueue adds a message without blocking
+func (q *Queue) Enqueue(msg Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits for pending ones to be delivered
+func (q *Queue) Close() {
+	close(q.messages)
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for msg := range q.messages {
+		q.deliver(msg)
+	}
+}
+
+// deliver tries to send the message, doubling the wait after each failure
+func (q *Queue) deliver(msg Message) {
+	wait := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := q.mailer.Send(msg)
+		if err == nil {
+			return
+		}
+		if attempt >= q.maxAttempts {
+			fmt.Printf("Giving up on email %q to %s after %d attempts: %v\n", msg.Subject, msg.To, attempt, err)
+			return
+		}
+		fmt.Printf("Email %q to %s failed (attempt %d/%d), retrying in %v: %v\n",
+			msg.Subject, msg.To, attempt, q.maxAttempts, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email has a <name>.txt and a <name>.html template. The text template
+// also defines the "<name>_subject" block.
+//
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render executes the named email template with data
+func Render(name string, data interface{}) (Message, error) {
+	var msg Message
+
+	text := textTemplates.Lookup(name + ".txt")
+	html := htmlTemplates.Lookup(name + ".html")
+	if text == nil || html == nil {
+		return msg, &UnknownTemplateError{Name: name}
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, name+"_subject", data); err != nil {
+		return msg, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return msg, err
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return msg, err
+	}
+
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.Text = textBody.String()
+	msg.HTML = htmlBody.String()
+	return msg, nil
+}
+
+// UnknownTemplateError is returned by Render for missing templates
+type UnknownTemplateError struct {
+	Name string
+}
+
+func (e *UnknownTemplateError) Error() string {
+	return "unknown email template: " + e.Name
+}
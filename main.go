@@ -6,64 +6,75 @@ import (
 	"os"
 
 	"cbc-backend/config"
+	"cbc-backend/mailer"
 	"cbc-backend/models"
+	"cbc-backend/oidc"
+	"cbc-backend/tasks"
 
 	"github.com/beego/beego/v2/client/orm"
 	"github.com/beego/beego/v2/core/logs"
 	beego "github.com/beego/beego/v2/server/web"
-	"github.com/beego/beego/v2/server/web/context"
-	"github.com/beego/beego/v2/server/web/filter/cors"
 )
 
-// init initializes the application by setting up required directories
-func init() {
+// setup loads the configuration, connects to the database and makes sure
+// every table exists. Every command runs it once its arguments are parsed.
+func setup() error {
 	// Load configuration
 	if err := config.LoadConfig(); err != nil {
-		logs.Error("Failed to load configuration:", err)
-		os.Exit(1)
-	}
-
-	// Create uploads directory if it doesn't exist
-	// This directory is used to store uploaded resource files
-	if err := os.MkdirAll("uploads", 0755); err != nil {
-		logs.Error("Failed to create uploads directory:", err)
+		return fmt.Errorf("failed to load configuration: %v", err)
 	}
 
 	// Initialize database connection
 	if err := models.InitDB(config.GetDBConnString()); err != nil {
-		logs.Error("Failed to initialize database:", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to initialize database: %v", err)
 	}
 
 	// Initialize database tables
-	if err := models.EnsureUsersTable(); err != nil {
-		logs.Error("Failed to create users table:", err)
-		os.Exit(1)
-	}
-	if err := models.EnsurePasswordResetTable(); err != nil {
-		logs.Error("Failed to create password_resets table:", err)
-		os.Exit(1)
-	}
+	return models.EnsureTables()
 }
 
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the HTTP API server
+func serve(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments")
+	}
+	if err := setup(); err != nil {
+		return err
+	}
+
 	fmt.Println("\n=== CBC Backend Service Initialization ===")
 
+	// Start the email delivery queue
+	if err := mailer.Init(); err != nil {
+		return fmt.Errorf("failed to initialize mailer: %v", err)
+	}
+
+	// Set up sign in with an OpenID Connect provider, if configured
+	oidc.Init()
+
+	// Create uploads directory if it doesn't exist
+	// This directory is used to store uploaded resource files
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		logs.Error("Failed to create uploads directory:", err)
+	}
+
 	// Test database connection
 	if err := testDatabaseConnection(); err != nil {
-		logs.Error("Database test failed:", err)
-		os.Exit(1)
+		return err
 	}
 	logs.Info("✓ Database connected successfully")
 
-	// Configure CORS
-	beego.InsertFilter("*", beego.BeforeRouter, cors.Allow(&cors.Options{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
+	// Create the first JWT signing key, or a new one if rotation is due
+	if _, err := models.RotateSigningKeys(false); err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %v", err)
+	}
+
+	// Start the periodic tasks, such as erasing deleted accounts
+	tasks.Init()
 
 	// Configure development mode settings
 	if beego.BConfig.RunMode == "dev" {
@@ -83,25 +94,6 @@ func main() {
 	beego.BConfig.Listen.HTTPAddr = "localhost"
 	beego.BConfig.Listen.HTTPPort = 8081
 
-	// Configure CORS middleware
-	// This allows cross-origin requests from frontend applications
-	beego.InsertFilter("*", beego.BeforeRouter, func(ctx *context.Context) {
-		origin := ctx.Input.Header("Origin")
-		if origin != "" {
-			// Set CORS headers to allow cross-origin requests
-			ctx.Output.Header("Access-Control-Allow-Origin", origin)
-			ctx.Output.Header("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-			ctx.Output.Header("Access-Control-Allow-Headers", "Origin,Content-Type,Accept,Authorization")
-			ctx.Output.Header("Access-Control-Allow-Credentials", "true")
-		}
-
-		// Handle preflight OPTIONS requests
-		if ctx.Input.Method() == "OPTIONS" {
-			ctx.Output.SetStatus(200)
-			return
-		}
-	})
-
 	// Start the server
 	fmt.Println("\nStarting server...")
 	fmt.Printf("RunMode: %s\n", beego.BConfig.RunMode)
@@ -112,6 +104,7 @@ func main() {
 
 	fmt.Println("=== Initialization Complete ===")
 	beego.Run()
+	return nil
 }
 
 func testDatabaseConnection() error {
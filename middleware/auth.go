@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"cbc-backend/auth"
+	"errors"
+
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// Authenticate identifies the caller and stores the principal in the request
+// context for handlers to read with auth.FromContext. It responds with 401
+// when the credentials are missing or invalid.
+func Authenticate(ctx *context.Context) {
+	principal, err := auth.Authenticate(ctx)
+	if err != nil {
+		var authErr *auth.Error
+		if errors.As(err, &authErr) && authErr.Status == 403 {
+			forbidden(ctx, authErr.Message)
+			return
+		}
+		unauthorized(ctx, err.Error())
+		return
+	}
+
+	auth.SetPrincipal(ctx, principal)
+}
+
+func unauthorized(ctx *context.Context, message string) {
+	ctx.Output.SetStatus(401)
+	ctx.Output.JSON(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	}, true, false)
+}
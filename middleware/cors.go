@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"cbc-backend/config"
+	"strings"
+
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// CORS headers. Credentials are allowed so that the frontend can use the
+// session cookie, which is why origins must be allowlisted.
+const (
+	corsAllowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Origin, Accept, Authorization, Content-Type, X-CSRF-Token, X-API-Key"
+	corsMaxAge       = "600"
+)
+
+// CORS lets the frontends in config.CORSAllowedOrigins make credentialed
+// cross-origin requests. Other origins get no CORS headers, so browsers
+// don't let them read responses. Preflight requests are answered here.
+func CORS(ctx *context.Context) {
+	origin := ctx.Input.Header("Origin")
+	ctx.Output.Header("Vary", "Origin")
+	allowed := origin != "" && originAllowed(origin)
+	if allowed {
+		ctx.Output.Header("Access-Control-Allow-Origin", origin)
+		ctx.Output.Header("Access-Control-Allow-Credentials", "true")
+		ctx.Output.Header("Access-Control-Expose-Headers", "Content-Length, Retry-After")
+	}
+
+	if ctx.Input.Method() == "OPTIONS" && ctx.Input.Header("Access-Control-Request-Method") != "" {
+		if allowed {
+			ctx.Output.Header("Access-Control-Allow-Methods", corsAllowMethods)
+			ctx.Output.Header("Access-Control-Allow-Headers", corsAllowHeaders)
+			ctx.Output.Header("Access-Control-Max-Age", corsMaxAge)
+		}
+		ctx.Output.SetStatus(204)
+		ctx.Output.Body(nil)
+	}
+}
+
+// originAllowed reports whether the origin is allowlisted. Origins compare
+// case-insensitively, as scheme and host are case-insensitive.
+func originAllowed(origin string) bool {
+	for _, allowed := range config.CORSAllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
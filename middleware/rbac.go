@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"strings"
+
+	"github.com/beego/beego/v2/core/logs"
+	"github.com/beego/beego/v2/server/web/context"
+)
+
+// Rule declares what a route requires of the caller. The zero Rule only
+// requires an authenticated user.
+type Rule struct {
+	Public      bool
+	Roles       []models.Role       // Caller must have one of these roles
+	Permissions []models.Permission // Caller's role must grant all of these
+	// AllowWithoutMFA lets users whose role requires two-factor
+	// authentication in, even if they signed in without it, so that they
+	// can enroll
+	AllowWithoutMFA bool
+}
+
+// Public allows anonymous access
+var Public = Rule{Public: true}
+
+// Authenticated allows any signed-in user
+var Authenticated = Rule{}
+
+// AuthenticatedWithoutMFA allows any signed-in user, including those who
+// still have to set up required two-factor authentication
+var AuthenticatedWithoutMFA = Rule{AllowWithoutMFA: true}
+
+// RequireRoles allows users having any of the given roles
+func RequireRoles(roles ...models.Role) Rule {
+	return Rule{Roles: roles}
+}
+
+// RequirePermissions allows users whose role grants every given permission
+func RequirePermissions(permissions ...models.Permission) Rule {
+	return Rule{Permissions: permissions}
+}
+
+// String describes the rule for route listings
+func (r Rule) String() string {
+	switch {
+	case r.Public:
+		return "public"
+	case len(r.Roles) > 0:
+		roles := make([]string, len(r.Roles))
+		for i, role := range r.Roles {
+			roles[i] = string(role)
+		}
+		return "roles: " + strings.Join(roles, "|")
+	case len(r.Permissions) > 0:
+		permissions := make([]string, len(r.Permissions))
+		for i, permission := range r.Permissions {
+			permissions[i] = string(permission)
+		}
+		return "permissions: " + strings.Join(permissions, ",")
+	}
+	return "authenticated"
+}
+
+// allows reports whether the principal satisfies the rule. Users are checked
+// by their role.
+func (r Rule) allows(p *auth.Principal) bool {
+	if p.Method == auth.MethodAPIKey {
+		return r.allowsAPIKey(p)
+	}
+	if !p.Role.Valid() {
+		return false
+	}
+
+	if len(r.Roles) > 0 {
+		matched := false
+		for _, allowed := range r.Roles {
+			if p.Role == allowed {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, permission := range r.Permissions {
+		if !p.Can(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowsAPIKey reports whether an API key principal satisfies the rule. Keys
+// only carry scopes, so they can use routes that require permissions but not
+// routes that require a role or a signed-in user.
+func (r Rule) allowsAPIKey(p *auth.Principal) bool {
+	if len(r.Roles) > 0 || len(r.Permissions) == 0 {
+		return false
+	}
+	for _, permission := range r.Permissions {
+		if !p.Can(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorize returns a route policy enforcing the rule. It authenticates the
+// caller (401 on missing or invalid credentials) and then checks the rule
+// against the caller's role (403 when it isn't satisfied).
+func Authorize(rule Rule) func(ctx *context.Context) {
+	return func(ctx *context.Context) {
+		if rule.Public {
+			return
+		}
+
+		Authenticate(ctx)
+		if ctx.ResponseWriter.Started {
+			return
+		}
+
+		principal, _ := auth.FromContext(ctx)
+		if principal.Method == auth.MethodAPIKey {
+			if !rule.allows(principal) {
+				forbidden(ctx, "This API key does not have the required scope")
+			}
+			return
+		}
+
+		if !rule.allows(principal) {
+			forbidden(ctx, "You do not have permission to access this resource")
+			return
+		}
+
+		if !principal.MFA && !rule.AllowWithoutMFA && mfaRequired(principal.Role) {
+			forbidden(ctx, "Two-factor authentication is required for your account. Enroll and sign in again with a code.")
+			return
+		}
+
+		if rule.restrictedWhenUnverified() {
+			verified, err := models.IsUserVerified(principal.ID)
+			if err != nil || !verified {
+				forbidden(ctx, "Please verify your email address to access this resource")
+			}
+		}
+	}
+}
+
+// mfaRequired reports whether users with the role must sign in with 2FA
+func mfaRequired(role models.Role) bool {
+	for _, required := range config.MFARequiredRoles {
+		if string(role) == required {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictedWhenUnverified reports whether the rule requires a permission
+// that unverified accounts are denied
+func (r Rule) restrictedWhenUnverified() bool {
+	for _, permission := range r.Permissions {
+		for _, restricted := range config.UnverifiedRestrictedPermissions {
+			if string(permission) == restricted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forbidden responds with 403 and records the denial in the audit log
+func forbidden(ctx *context.Context, message string) {
+	event := &models.AuditEvent{
+		Action:     models.AuditAccessDenied,
+		TargetType: "route",
+		TargetID:   ctx.Input.Method() + " " + ctx.Input.URL(),
+		IP:         ctx.Input.IP(),
+		UserAgent:  ctx.Input.UserAgent(),
+		Result:     models.AuditDenied,
+	}
+	if principal, ok := auth.FromContext(ctx); ok {
+		event.ActorID = principal.ID
+		event.ActorName = principal.Username
+	}
+	event.SetDetails(map[string]interface{}{"reason": message})
+	if err := models.RecordAuditEvent(event); err != nil {
+		logs.Error(err)
+	}
+
+	ctx.Output.SetStatus(403)
+	ctx.Output.JSON(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	}, true, false)
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/google/uuid"
+)
+
+// APIKeyPrefix starts every API key so that leaked keys are easy to spot
+const APIKeyPrefix = "cbc_"
+
+// ErrInvalidAPIKey is returned for unknown, revoked or expired API keys
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+// APIKey lets a service account such as the resource crawler call the API.
+// It grants only its scopes, and acts on behalf of the admin who created it.
+// Only the hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         string     `orm:"pk;size(36);column(id)" json:"id"`
+	Name       string     `orm:"column(name);size(128)" json:"name"`
+	Prefix     string     `orm:"column(prefix);size(16)" json:"prefix"`
+	KeyHash    string     `orm:"column(key_hash);size(64);unique" json:"-"`
+	Scopes     string     `orm:"column(scopes);size(255)" json:"-"`
+	CreatedBy  string     `orm:"column(created_by);size(36)" json:"created_by"`
+	ExpiresAt  time.Time  `orm:"column(expires_at);type(timestamp with time zone)" json:"expires_at"`
+	LastUsedAt *time.Time `orm:"column(last_used_at);null;type(timestamp with time zone)" json:"last_used_at"`
+	RevokedAt  *time.Time `orm:"column(revoked_at);null;type(timestamp with time zone)" json:"revoked_at"`
+	CreatedAt  time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (k *APIKey) TableName() string {
+	return "api_keys"
+}
+
+// Permissions returns the scopes granted to the key
+func (k *APIKey) Permissions() []Permission {
+	var permissions []Permission
+	for _, scope := range strings.Split(k.Scopes, ",") {
+		if scope != "" {
+			permissions = append(permissions, Permission(scope))
+		}
+	}
+	return permissions
+}
+
+// Can reports whether the key was granted the permission
+func (k *APIKey) Can(p Permission) bool {
+	for _, granted := range k.Permissions() {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureAPIKeysTable creates the api_keys table if it doesn't exist
+func EnsureAPIKeysTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id VARCHAR(36) PRIMARY KEY,
+		name VARCHAR(128) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) UNIQUE NOT NULL,
+		scopes VARCHAR(255) NOT NULL,
+		created_by VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	o := orm.NewOrm()
+	_, err := o.Raw(sql).Exec()
+	return err
+}
+
+// ParsePermission converts a string to a Permission, rejecting unknown values
+func ParsePermission(s string) (Permission, error) {
+	permission := Permission(strings.ToLower(strings.TrimSpace(s)))
+	for _, granted := range rolePermissions[RoleAdmin] {
+		if permission == granted {
+			return permission, nil
+		}
+	}
+	return "", fmt.Errorf("invalid scope %q", s)
+}
+
+// CreateAPIKey stores a new API key and returns it along with the plaintext
+// key, which is never stored and can't be shown again
+func CreateAPIKey(name string, scopes []Permission, expiresAt time.Time, createdBy string) (*APIKey, string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := APIKeyPrefix + token
+
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    plaintext[:len(APIKeyPrefix)+8],
+		KeyHash:   hashToken(plaintext),
+		Scopes:    strings.Join(names, ","),
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	o := orm.NewOrm()
+	_, err = o.Raw(`INSERT INTO api_keys (id, name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+					 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedBy, key.ExpiresAt, key.CreatedAt).Exec()
+	if err != nil {
+		return nil, "", err
+	}
+	return key, plaintext, nil
+}
+
+// AuthenticateAPIKey returns the active key matching the plaintext key and
+// records that it was used
+func AuthenticateAPIKey(plaintext string) (*APIKey, error) {
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	o := orm.NewOrm()
+	var key APIKey
+	// Keys stop working while the admin who created them is suspended or
+	// in the trash
+	err := o.Raw(`SELECT k.* FROM api_keys k JOIN users u ON u.id = k.created_by
+				  WHERE k.key_hash = ? AND k.revoked_at IS NULL AND k.expires_at > CURRENT_TIMESTAMP
+				  AND u.suspended_at IS NULL AND u.deleted_at IS NULL`,
+		hashToken(plaintext)).QueryRow(&key)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Busy integrations call the API constantly; a minute's precision is enough
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		if _, err := o.Raw(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, key.ID).Exec(); err != nil {
+			fmt.Printf("Failed to record API key use: %v\n", err)
+		}
+		key.LastUsedAt = &now
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key, newest first
+func ListAPIKeys() ([]APIKey, error) {
+	o := orm.NewOrm()
+	var keys []APIKey
+	_, err := o.Raw(`SELECT * FROM api_keys ORDER BY created_at DESC`).QueryRows(&keys)
+	return keys, err
+}
+
+// RevokeAPIKey stops an API key from being accepted
+func RevokeAPIKey(id string) error {
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// Audit event actions
+const (
+	AuditLogin                  = "auth.login"
+	AuditPasswordResetRequested = "auth.password_reset_requested"
+	AuditPasswordReset          = "auth.password_reset"
+	AuditPasswordResetForced    = "auth.password_reset_forced"
+	AuditAccessDenied           = "auth.access_denied"
+	AuditDeviceSignedOut        = "auth.device_signed_out"
+	AuditSignedOutEverywhere    = "auth.signed_out_everywhere"
+	AuditRoleChanged            = "user.role_changed"
+	AuditUserSuspended          = "user.suspended"
+	AuditUserReactivated        = "user.reactivated"
+	AuditUserUnlocked           = "user.unlocked"
+	AuditUserDeleted            = "user.deleted"
+	AuditErasureScheduled       = "user.erasure_scheduled"
+	AuditErasureCancelled       = "user.erasure_cancelled"
+	AuditDataExported           = "user.data_exported"
+	AuditUploadDeleted          = "upload.deleted"
+	AuditRestored               = "trash.restored"
+	AuditTrashPurged            = "trash.purged"
+	AuditAPIKeyCreated          = "api_key.created"
+	AuditAPIKeyRevoked          = "api_key.revoked"
+	AuditSigningKeyRotated      = "jwt.key_rotated"
+	AuditResourceUploaded       = "resource.uploaded"
+	AuditJobCreated             = "job.created"
+	AuditJobUpdated             = "job.updated"
+	AuditJobDeleted             = "job.deleted"
+	AuditOrgCreated             = "organization.created"
+	AuditOrgUpdated             = "organization.updated"
+	AuditOrgDeleted             = "organization.deleted"
+	AuditOrgMemberInvited       = "organization.member_invited"
+	AuditOrgInvitationRevoked   = "organization.invitation_revoked"
+	AuditOrgInvitationAccepted  = "organization.invitation_accepted"
+	AuditOrgInvitationDeclined  = "organization.invitation_declined"
+	AuditOrgMemberRoleChanged   = "organization.member_role_changed"
+	AuditOrgMemberRemoved       = "organization.member_removed"
+)
+
+// Audit event results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEvent records a security relevant action. Rows are never updated or
+// deleted; the table rejects both. ActorID and TargetID aren't foreign keys
+// so that events outlive the users they mention.
+type AuditEvent struct {
+	ID         int64     `orm:"pk;auto;column(id)" json:"id"`
+	ActorID    string    `orm:"column(actor_id);size(36)" json:"actor_id"`
+	ActorName  string    `orm:"column(actor_name);size(128)" json:"actor_name"`
+	Action     string    `orm:"column(action);size(64)" json:"action"`
+	TargetType string    `orm:"column(target_type);size(32)" json:"target_type"`
+	TargetID   string    `orm:"column(target_id);size(255)" json:"target_id"`
+	IP         string    `orm:"column(ip);size(45)" json:"ip"`
+	UserAgent  string    `orm:"column(user_agent);size(512)" json:"user_agent"`
+	Result     string    `orm:"column(result);size(16)" json:"result"`
+	Details    string    `orm:"column(details);type(text)" json:"details"`
+	CreatedAt  time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (e *AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// EnsureAuditEventsTable creates the audit_events table if it doesn't exist,
+// along with the trigger that makes it append-only
+func EnsureAuditEventsTable() error {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGSERIAL PRIMARY KEY,
+		actor_id VARCHAR(36) NOT NULL DEFAULT '',
+		actor_name VARCHAR(128) NOT NULL DEFAULT '',
+		action VARCHAR(64) NOT NULL,
+		target_type VARCHAR(32) NOT NULL DEFAULT '',
+		target_id VARCHAR(255) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL DEFAULT '',
+		user_agent VARCHAR(512) NOT NULL DEFAULT '',
+		result VARCHAR(16) NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at)`,
+		`CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id)`, `
+	CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_events is append-only';
+	END;
+	$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+		`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+		 FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDetails stores extra information about the event as JSON
+func (e *AuditEvent) SetDetails(details map[string]interface{}) {
+	if len(details) == 0 {
+		return
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return
+	}
+	e.Details = string(data)
+}
+
+// AuditResult returns the audit result for an action's error
+func AuditResult(err error) string {
+	if err != nil {
+		return AuditFailure
+	}
+	return AuditSuccess
+}
+
+// RecordAuditEvent appends the event to the audit log. Values come from
+// clients, so they are made valid for their columns first: a value Postgres
+// rejects would otherwise let a client keep its actions out of the log.
+func RecordAuditEvent(e *AuditEvent) error {
+	e.ActorID = auditText(e.ActorID, 36)
+	e.ActorName = auditText(e.ActorName, 128)
+	e.Action = auditText(e.Action, 64)
+	e.TargetType = auditText(e.TargetType, 32)
+	e.TargetID = auditText(e.TargetID, 255)
+	e.IP = auditText(e.IP, 45)
+	e.UserAgent = auditText(e.UserAgent, 512)
+	e.Result = auditText(e.Result, 16)
+	e.Details = auditText(e.Details, 0)
+
+	o := orm.NewOrm()
+	_, err := o.Raw(`INSERT INTO audit_events (actor_id, actor_name, action, target_type, target_id, ip, user_agent, result, details, created_at)
+					 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		e.ActorID, e.ActorName, e.Action, e.TargetType, e.TargetID, e.IP, e.UserAgent, e.Result, e.Details).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to record audit event %s: %v", e.Action, err)
+	}
+	return nil
+}
+
+// auditText replaces invalid UTF-8 and drops NUL bytes, which Postgres
+// rejects in text, then cuts the value to at most max characters (VARCHAR
+// limits count characters, not bytes). A max of 0 doesn't cut.
+func auditText(value string, max int) string {
+	value = strings.ToValidUTF8(strings.ReplaceAll(value, "\x00", ""), "\uFFFD")
+	if max > 0 && utf8.RuneCountInString(value) > max {
+		value = string([]rune(value)[:max])
+	}
+	return value
+}
+
+// AuditFilter narrows ListAuditEvents. Zero values don't filter.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	Result     string
+	IP         string
+	Since      time.Time
+	Until      time.Time
+}
+
+// auditQuery applies the filter to a query on audit_events
+func auditQuery(o orm.Ormer, filter AuditFilter) orm.QuerySeter {
+	qs := o.QueryTable("audit_events")
+	if filter.ActorID != "" {
+		qs = qs.Filter("actor_id", filter.ActorID)
+	}
+	if filter.Action != "" {
+		qs = qs.Filter("action", filter.Action)
+	}
+	if filter.TargetType != "" {
+		qs = qs.Filter("target_type", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		qs = qs.Filter("target_id", filter.TargetID)
+	}
+	if filter.Result != "" {
+		qs = qs.Filter("result", filter.Result)
+	}
+	if filter.IP != "" {
+		qs = qs.Filter("ip", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		qs = qs.Filter("created_at__gte", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		qs = qs.Filter("created_at__lt", filter.Until)
+	}
+	return qs
+}
+
+// ListAuditEvents returns a page of matching events, newest first, together
+// with the total number of matches
+func ListAuditEvents(filter AuditFilter, page, pageSize int) ([]AuditEvent, int64, error) {
+	qs := auditQuery(orm.NewOrm(), filter)
+
+	total, err := qs.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var events []AuditEvent
+	_, err = qs.OrderBy("-created_at", "-id").Limit(pageSize).Offset((page - 1) * pageSize).All(&events)
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// EachAuditEvent calls fn for every matching event, oldest first, loading
+// them in batches so that large exports don't have to fit in memory
+func EachAuditEvent(filter AuditFilter, fn func(*AuditEvent) error) error {
+	const batchSize = 1000
+
+	o := orm.NewOrm()
+	var lastID int64
+	for {
+		var events []AuditEvent
+		_, err := auditQuery(o, filter).Filter("id__gt", lastID).OrderBy("id").Limit(batchSize).All(&events)
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < batchSize {
+			return nil
+		}
+		lastID = events[len(events)-1].ID
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditText(t *testing.T) {
+	assert.Equal(t, "admin", auditText("admin", 16))
+	assert.Equal(t, "admin", auditText("ad\x00min", 16))
+	assert.Equal(t, "bad�byte", auditText("bad\xffbyte", 0))
+
+	// Values are cut by characters, never inside one
+	assert.Equal(t, "héé", auditText("hééllo", 3))
+	assert.Equal(t, "日本", auditText("日本語", 2))
+}
@@ -39,3 +39,37 @@ func TestDatabaseConnection() error {
 	fmt.Printf("✅ Found %d uploads in uploads table\n", count)
 	return nil
 }
+
+// EnsureTables creates the tables the app needs and runs their migrations
+func EnsureTables() error {
+	tables := []struct {
+		name   string
+		ensure func() error
+	}{
+		{"users", EnsureUsersTable},
+		{"organizations", EnsureOrganizationTables},
+		{"uploads", EnsureUploadsTable},
+		{"resource_downloads", EnsureResourceDownloadsTable},
+		{"resource search index", EnsureResourceSearchIndex},
+		{"resource_metadata", EnsureResourceMetadataTable},
+		{"jobs", EnsureJobsTable},
+		{"refresh_tokens", EnsureRefreshTokensTable},
+		{"revoked_tokens", EnsureRevokedTokensTable},
+		{"user_devices", EnsureDevicesTable},
+		{"session", EnsureSessionsTable},
+		{"one_time_tokens", EnsureOneTimeTokensTable},
+		{"login_attempts", EnsureLoginAttemptsTable},
+		{"MFA", EnsureMFATables},
+		{"identity", EnsureIdentityTables},
+		{"api_keys", EnsureAPIKeysTable},
+		{"user_profiles", EnsureUserProfilesTable},
+		{"audit_events", EnsureAuditEventsTable},
+		{"jwt_signing_keys", EnsureJWTSigningKeysTable},
+	}
+	for _, table := range tables {
+		if err := table.ensure(); err != nil {
+			return fmt.Errorf("failed to create %s table: %v", table.name, err)
+		}
+	}
+	return nil
+}
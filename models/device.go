@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// deviceTouchInterval is how often a device's last seen time is updated
+// while it keeps using the same IP address
+const deviceTouchInterval = time.Minute
+
+// ErrDeviceSignedOut is returned for credentials of a device that was signed
+// out remotely
+var ErrDeviceSignedOut = errors.New("this device has been signed out")
+
+// Device is a signed-in device, created at each successful login. Its ID is
+// the login's refresh token family, which the access tokens carry, and its
+// cookie session points to it, so signing a device out ends every credential
+// of that login at once.
+type Device struct {
+	ID         string    `orm:"pk;size(36);column(id)" json:"id"`
+	UserID     string    `orm:"column(user_id);size(36)" json:"-"`
+	UserAgent  string    `orm:"column(user_agent);type(text)" json:"user_agent"`
+	IP         string    `orm:"column(ip);size(45)" json:"ip"` // Last seen from
+	CreatedAt  time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+	LastSeenAt time.Time `orm:"type(timestamp with time zone);column(last_seen_at)" json:"last_seen_at"`
+}
+
+// TableName specifies the database table name
+func (d *Device) TableName() string {
+	return "user_devices"
+}
+
+// EnsureDevicesTable creates the user_devices table if it doesn't exist, with
+// a device for each login whose refresh tokens are still valid, so that
+// access tokens issued before devices were tracked keep working
+func EnsureDevicesTable() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS user_devices (
+			id VARCHAR(36) PRIMARY KEY,
+			user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS user_devices_user_id_idx ON user_devices (user_id)`,
+		`INSERT INTO user_devices (id, user_id, created_at, last_seen_at)
+		 SELECT family_id, MIN(user_id), MIN(created_at), MAX(created_at) FROM refresh_tokens
+		 WHERE revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 GROUP BY family_id
+		 ON CONFLICT (id) DO NOTHING`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateDevice records a login of the user from a device. id is the login's
+// refresh token family.
+func CreateDevice(id, userID, userAgent, ip string) (*Device, error) {
+	now := time.Now()
+	device := &Device{
+		ID:         id,
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	o := orm.NewOrm()
+	_, err := o.Raw(`INSERT INTO user_devices (id, user_id, user_agent, ip, created_at, last_seen_at)
+					 VALUES (?, ?, ?, ?, ?, ?)`,
+		device.ID, device.UserID, device.UserAgent, device.IP, device.CreatedAt, device.LastSeenAt).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// TouchDevice checks that the user's device is still signed in and records
+// that it was seen from ip. It returns ErrDeviceSignedOut if the device was
+// signed out.
+func TouchDevice(id, userID, ip string) error {
+	o := orm.NewOrm()
+	var device Device
+	err := o.Raw(`SELECT * FROM user_devices WHERE id = ? AND user_id = ?`, id, userID).QueryRow(&device)
+	if errors.Is(err, orm.ErrNoRows) {
+		return ErrDeviceSignedOut
+	}
+	if err != nil {
+		return err
+	}
+
+	if device.IP == ip && time.Since(device.LastSeenAt) < deviceTouchInterval {
+		return nil
+	}
+	_, err = o.Raw(`UPDATE user_devices SET last_seen_at = CURRENT_TIMESTAMP, ip = ? WHERE id = ?`, ip, id).Exec()
+	return err
+}
+
+// ListDevices returns the devices the user is signed in on, most recently
+// seen first
+func ListDevices(userID string) ([]Device, error) {
+	o := orm.NewOrm()
+	devices := []Device{}
+	_, err := o.Raw(`SELECT * FROM user_devices WHERE user_id = ? ORDER BY last_seen_at DESC`, userID).
+		QueryRows(&devices)
+	return devices, err
+}
+
+// SignOutDevice signs one of the user's devices out: its cookie session is
+// deleted, its refresh tokens are revoked and its access tokens stop being
+// accepted. It returns orm.ErrNoRows if the user has no such device.
+func SignOutDevice(userID, id string) error {
+	o := orm.NewOrm()
+	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		// Sessions cascade from their device
+		result, err := txOrm.Raw(`DELETE FROM user_devices WHERE id = ? AND user_id = ?`, id, userID).Exec()
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return orm.ErrNoRows
+		}
+
+		_, err = txOrm.Raw(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+							WHERE family_id = ? AND revoked_at IS NULL`, id).Exec()
+		return err
+	})
+}
+
+// DeleteUserDevices signs out every device of the user
+func DeleteUserDevices(userID string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`DELETE FROM user_devices WHERE user_id = ?`, userID).Exec()
+	return err
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ErrErasureNotScheduled is returned when cancelling an erasure that isn't
+// pending
+var ErrErasureNotScheduled = errors.New("account erasure is not scheduled")
+
+// ScheduleUserErasure marks the account for erasure at the given time. The
+// user can keep signing in, e.g. to export their data, until then. The last
+// active admin can't be scheduled, nor the only owner of an organization
+// with no admin to take it over.
+func ScheduleUserErasure(userID string, at time.Time) (*User, error) {
+	var user User
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		if err := txOrm.Raw(`SELECT * FROM users WHERE id = ? FOR UPDATE`, userID).QueryRow(&user); err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			if err := ensureOtherAdmin(txOrm, userID); err != nil {
+				return err
+			}
+		}
+		if err := handOverOwnership(txOrm, userID, true); err != nil {
+			return err
+		}
+
+		// Asking again doesn't push back an erasure that's already due
+		if user.ErasureScheduledAt != nil && user.ErasureScheduledAt.Before(at) {
+			return nil
+		}
+		user.ErasureScheduledAt = &at
+		_, err := txOrm.Raw(`UPDATE users SET erasure_scheduled_at = ? WHERE id = ?`, at, userID).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CancelUserErasure keeps an account that was scheduled for erasure
+func CancelUserErasure(userID string) error {
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE users SET erasure_scheduled_at = NULL WHERE id = ? AND erasure_scheduled_at IS NOT NULL`,
+		userID).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrErasureNotScheduled
+	}
+	return nil
+}
+
+// EraseUser deletes the account and everything it owns: uploads and their
+// files, posted jobs, password resets and login attempts, plus the tables
+// that cascade from users (sessions, tokens, profile, MFA, identities and
+// API keys). Audit events are kept as the security record. Organizations the
+// user is the only owner of pass to their longest-standing admin; without
+// one, the account isn't erased and ErrNoOrgSuccessor is returned.
+func EraseUser(userID string) error {
+	var filePaths []string
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var user User
+		if err := txOrm.Raw(`SELECT * FROM users WHERE id = ? FOR UPDATE`, userID).QueryRow(&user); err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			if err := ensureOtherAdmin(txOrm, userID); err != nil {
+				return err
+			}
+		}
+		if err := handOverOwnership(txOrm, userID, false); err != nil {
+			return err
+		}
+
+		if _, err := txOrm.Raw(`SELECT file_path FROM uploads WHERE user_id = ?`, userID).QueryRows(&filePaths); err != nil {
+			return err
+		}
+
+		statements := []struct {
+			sql  string
+			args []interface{}
+		}{
+			{`DELETE FROM uploads WHERE user_id = ?`, []interface{}{userID}},
+			{`DELETE FROM jobs WHERE posted_by = ?`, []interface{}{userID}},
+			{`DELETE FROM one_time_tokens WHERE user_id = ?`, []interface{}{userID}},
+			{`DELETE FROM login_attempts WHERE username = ?`, []interface{}{normalizeLoginName(user.Username)}},
+			{`DELETE FROM users WHERE id = ?`, []interface{}{userID}},
+		}
+		for _, stmt := range statements {
+			if _, err := txOrm.Raw(stmt.sql, stmt.args...).Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files go only once the rows are gone; a leftover file is harmless
+	// but a row pointing at a missing file isn't
+	for _, filePath := range filePaths {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: Failed to remove %s: %v\n", filePath, err)
+		}
+	}
+	return nil
+}
+
+// EraseDueUsers erases every account whose erasure time has passed and
+// returns how many were erased. An account that can't be erased, e.g. the
+// last admin, is logged and left for the next run.
+func EraseDueUsers() (int, error) {
+	o := orm.NewOrm()
+	var ids []string
+	_, err := o.Raw(`SELECT id FROM users WHERE erasure_scheduled_at <= CURRENT_TIMESTAMP`).QueryRows(&ids)
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, id := range ids {
+		err := EraseUser(id)
+		auditErr := RecordAuditEvent(&AuditEvent{
+			ActorName:  "scheduler",
+			Action:     AuditUserDeleted,
+			TargetType: "user",
+			TargetID:   id,
+			Result:     AuditResult(err),
+		})
+		if auditErr != nil {
+			fmt.Println(auditErr)
+		}
+		if err != nil {
+			fmt.Printf("Failed to erase user %s: %v\n", id, err)
+			continue
+		}
+		erased++
+	}
+	return erased, nil
+}
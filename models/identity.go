@@ -0,0 +1,289 @@
+package models
+
+import (
+	"cbc-backend/config"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidOIDCState is returned for unknown, used or expired login states
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+	// ErrExternalEmailNotVerified is returned when the provider hasn't
+	// verified the email address, so it can't be trusted for linking
+	ErrExternalEmailNotVerified = errors.New("the provider has not verified this email address")
+	// ErrIdentityLinkRefused is returned when the email belongs to a local
+	// account that hasn't verified it yet
+	ErrIdentityLinkRefused = errors.New("an unverified account already uses this email address; verify it or sign in with your password first")
+	// ErrAccountDeleted is returned when the matching account is in the trash
+	ErrAccountDeleted = errors.New("this account has been deleted")
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider. Provider is the issuer URL and Subject its stable user ID.
+type UserIdentity struct {
+	ID        int64     `orm:"pk;auto;column(id)" json:"id"`
+	UserID    string    `orm:"column(user_id);size(36)" json:"user_id"`
+	Provider  string    `orm:"column(provider);size(255)" json:"provider"`
+	Subject   string    `orm:"column(subject);size(255)" json:"subject"`
+	Email     string    `orm:"column(email);size(128)" json:"email"`
+	CreatedAt time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (i *UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OIDCLoginState holds the secrets of an OIDC login between the redirect to
+// the provider and the callback. Only the hash of the state is stored.
+type OIDCLoginState struct {
+	StateHash    string    `orm:"pk;column(state_hash);size(64)" json:"-"`
+	CodeVerifier string    `orm:"column(code_verifier);size(128)" json:"-"`
+	Nonce        string    `orm:"column(nonce);size(64)" json:"-"`
+	ExpiresAt    time.Time `orm:"column(expires_at);type(timestamp with time zone)" json:"expires_at"`
+	CreatedAt    time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (s *OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+// OIDCLogin is a started login: State, Nonce and the PKCE CodeVerifier
+type OIDCLogin struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+}
+
+// EnsureIdentityTables creates the user_identities and oidc_login_states tables if they don't exist
+func EnsureIdentityTables() error {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS user_identities (
+		id BIGSERIAL PRIMARY KEY,
+		user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider VARCHAR(255) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		email VARCHAR(128),
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, subject)
+	)`,
+		`CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id)`, `
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state_hash VARCHAR(64) PRIMARY KEY,
+		code_verifier VARCHAR(128) NOT NULL,
+		nonce VARCHAR(64) NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateOIDCLogin generates and stores the state, nonce and PKCE code
+// verifier for a new OIDC login
+func CreateOIDCLogin() (*OIDCLogin, error) {
+	var login OIDCLogin
+	for _, value := range []*string{&login.State, &login.CodeVerifier, &login.Nonce} {
+		token, err := newOpaqueToken()
+		if err != nil {
+			return nil, err
+		}
+		*value = token
+	}
+
+	o := orm.NewOrm()
+	// Logins that were abandoned at the provider are never consumed
+	if _, err := o.Raw(`DELETE FROM oidc_login_states WHERE expires_at < CURRENT_TIMESTAMP`).Exec(); err != nil {
+		return nil, err
+	}
+
+	_, err := o.Raw(`INSERT INTO oidc_login_states (state_hash, code_verifier, nonce, expires_at, created_at)
+					 VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		hashToken(login.State), login.CodeVerifier, login.Nonce, time.Now().Add(config.OIDCStateTTL)).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// ConsumeOIDCLogin looks up and deletes the login for a state returned by
+// the provider, so that each state can only be used once
+func ConsumeOIDCLogin(state string) (*OIDCLogin, error) {
+	var stored OIDCLoginState
+	o := orm.NewOrm()
+	err := o.Raw(`DELETE FROM oidc_login_states WHERE state_hash = ? AND expires_at > CURRENT_TIMESTAMP
+				  RETURNING *`, hashToken(state)).QueryRow(&stored)
+	if err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+
+	return &OIDCLogin{State: state, CodeVerifier: stored.CodeVerifier, Nonce: stored.Nonce}, nil
+}
+
+// OIDCStateBinding returns the value of the cookie that ties a login's state
+// to the browser that started it. It's the state's hash, as stored.
+func OIDCStateBinding(state string) string {
+	return hashToken(state)
+}
+
+// ExternalAccount is what an OIDC provider told us about a user
+type ExternalAccount struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// LinkExternalAccount returns the user linked to the external account. An
+// account seen for the first time is linked to the user with the same
+// verified email address, or a new user is created with the default role.
+// created reports whether a new user was created.
+func LinkExternalAccount(account ExternalAccount) (user *User, created bool, err error) {
+	o := orm.NewOrm()
+	err = o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		user, created = nil, false
+
+		var existing User
+		err := txOrm.Raw(`SELECT u.* FROM users u JOIN user_identities i ON i.user_id = u.id
+						  WHERE i.provider = ? AND i.subject = ?`, account.Provider, account.Subject).QueryRow(&existing)
+		if err == nil {
+			if existing.DeletedAt != nil {
+				return ErrAccountDeleted
+			}
+			user = &existing
+			return nil
+		}
+		if err != orm.ErrNoRows {
+			return err
+		}
+
+		// Emails are only trusted for linking once the provider verified them
+		email := strings.TrimSpace(account.Email)
+		if email == "" || !account.EmailVerified {
+			return ErrExternalEmailNotVerified
+		}
+
+		err = txOrm.Raw(`SELECT * FROM users WHERE LOWER(email) = LOWER(?) FOR UPDATE`, email).QueryRow(&existing)
+		switch {
+		case err == nil && existing.DeletedAt != nil:
+			return ErrAccountDeleted
+		case err == nil:
+			// Linking to an account whose owner never proved the address
+			// would hand it to whoever registered it, password included
+			if existing.VerifiedAt == nil {
+				return ErrIdentityLinkRefused
+			}
+			user = &existing
+		case err == orm.ErrNoRows:
+			user, err = createExternalUser(txOrm, email, account.Name)
+			if err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		_, err = txOrm.Raw(`INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+							VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+			user.ID, account.Provider, account.Subject, email).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+// createExternalUser creates a verified user for an external account. The
+// password is random, so the user signs in through the provider until they
+// set one with a password reset.
+func createExternalUser(o orm.QueryExecutor, email, name string) (*User, error) {
+	username, err := uniqueUsername(o, email, name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	password, err := HashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &User{
+		ID:         uuid.New().String(),
+		Username:   username,
+		Password:   password,
+		Email:      email,
+		Role:       DefaultRole,
+		VerifiedAt: &now,
+		CreatedAt:  now,
+	}
+
+	_, err = o.Raw(`INSERT INTO users (id, username, password, email, role, verified_at, created_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Username, user.Password, user.Email, user.Role, user.VerifiedAt, user.CreatedAt).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// uniqueUsername derives an unused username from the email address (or the
+// name if the local part is unusable), adding a numeric suffix if needed
+func uniqueUsername(o orm.QueryExecutor, email, name string) (string, error) {
+	base := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+	base = strings.Trim(usernameInvalidChars.ReplaceAllString(base, ""), "._-")
+	if base == "" {
+		base = strings.Trim(usernameInvalidChars.ReplaceAllString(strings.ToLower(name), "."), "._-")
+	}
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 64 {
+		base = base[:64]
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		var count int64
+		if err := o.Raw(`SELECT COUNT(*) FROM users WHERE username = ?`, candidate).QueryRow(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%04d", base, suffix.Int64())
+	}
+	return "", errors.New("could not find a free username")
+}
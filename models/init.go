@@ -11,6 +11,22 @@ func init() {
 		new(Upload),
 		new(Resource), // For reading only
 		new(Job),
-		new(PasswordReset),
+		new(RefreshToken),
+		new(RevokedToken),
+		new(Session),
+		new(Device),
+		new(OneTimeToken),
+		new(LoginAttempt),
+		new(UserMFA),
+		new(MFARecoveryCode),
+		new(UserIdentity),
+		new(OIDCLoginState),
+		new(APIKey),
+		new(UserProfile),
+		new(AuditEvent),
+		new(Organization),
+		new(OrganizationMember),
+		new(OrganizationInvitation),
+		new(JWTSigningKey),
 	)
 }
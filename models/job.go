@@ -2,19 +2,25 @@ package models
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/beego/beego/v2/client/orm"
 )
 
 type Job struct {
-	Id          int       `orm:"pk;auto" json:"id"`
-	Title       string    `orm:"size(100)" json:"title"`
-	Description string    `orm:"type(text)" json:"description"`
-	Location    string    `orm:"size(100)" json:"location"`
-	Type        string    `orm:"size(50)" json:"type"` // Full-time, Part-time, Contract
-	Salary      string    `orm:"size(50)" json:"salary"`
-	CreatedAt   time.Time `orm:"auto_now_add;type(timestamp)" json:"created_at"`
+	Id          int    `orm:"pk;auto" json:"id"`
+	Title       string `orm:"size(100)" json:"title"`
+	Description string `orm:"type(text)" json:"description"`
+	Location    string `orm:"size(100)" json:"location"`
+	Type        string `orm:"size(50)" json:"type"` // Full-time, Part-time, Contract
+	Salary      string `orm:"size(50)" json:"salary"`
+	PostedBy    string `orm:"column(posted_by);size(36)" json:"posted_by"` // ID of the user who posted the job
+	// OrganizationID is set for jobs posted on behalf of an organization
+	OrganizationID *string   `orm:"column(organization_id);size(36);null" json:"organization_id"`
+	CreatedAt      time.Time `orm:"auto_now_add;type(timestamp)" json:"created_at"`
+	// DeletedAt is set while the job is in the trash
+	DeletedAt *time.Time `orm:"null;type(timestamp with time zone);column(deleted_at)" json:"deleted_at,omitempty"`
 }
 
 const JobPageSize = 20
@@ -46,7 +52,7 @@ func SearchJobs(params map[string]string, page int) (*JobPagination, error) {
 	var jobs []*Job
 	o := orm.NewOrm()
 
-	query := o.QueryTable("jobs")
+	query := o.QueryTable("jobs").Filter("deleted_at__isnull", true)
 
 	// Apply filters
 	for key, value := range params {
@@ -93,24 +99,35 @@ func SearchJobs(params map[string]string, page int) (*JobPagination, error) {
 	}, nil
 }
 
-// GetJob retrieves a single job by ID
+// GetJob retrieves a single job by ID. Jobs in the trash aren't found.
 func GetJob(job *Job) error {
 	o := orm.NewOrm()
-	return o.Read(job)
+	return o.QueryTable("jobs").Filter("id", job.Id).Filter("deleted_at__isnull", true).One(job)
 }
 
-// UpdateJob updates an existing job
+// UpdateJob updates an existing job. The poster and creation time are kept,
+// and jobs in the trash can't be updated.
 func UpdateJob(job *Job) error {
 	o := orm.NewOrm()
-	_, err := o.Update(job)
-	return err
+	updated, err := o.QueryTable("jobs").Filter("id", job.Id).Filter("deleted_at__isnull", true).Update(orm.Params{
+		"title":       job.Title,
+		"description": job.Description,
+		"location":    job.Location,
+		"type":        job.Type,
+		"salary":      job.Salary,
+	})
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
 }
 
-// DeleteJob removes a job by ID
+// DeleteJob moves a job to the trash
 func DeleteJob(id int) error {
-	o := orm.NewOrm()
-	_, err := o.Delete(&Job{Id: id})
-	return err
+	return moveToTrash(TrashJob, strconv.Itoa(id))
 }
 
 // Add TableName method to specify the table name
@@ -119,7 +136,7 @@ func (j *Job) TableName() string {
 }
 
 func EnsureJobsTable() error {
-	sql := `
+	statements := []string{`
 	CREATE TABLE IF NOT EXISTS jobs (
 		id SERIAL PRIMARY KEY,
 		title VARCHAR(100) NOT NULL,
@@ -128,9 +145,20 @@ func EnsureJobsTable() error {
 		type VARCHAR(50),
 		salary VARCHAR(50),
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-	)`
+	)`,
+		// Jobs posted before posted_by existed have no poster
+		`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS posted_by VARCHAR(36) NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS jobs_posted_by_idx ON jobs (posted_by)`,
+		`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS organization_id VARCHAR(36) REFERENCES organizations(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS jobs_organization_id_idx ON jobs (organization_id)`,
+	}
 
 	o := orm.NewOrm()
-	_, err := o.Raw(sql).Exec()
-	return err
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
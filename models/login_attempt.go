@@ -0,0 +1,168 @@
+package models
+
+import (
+	"cbc-backend/config"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// LoginAttempt records a single login attempt. Attempts are tracked by the
+// submitted username, whether or not such an account exists, so that
+// throttling behaves identically for unknown users.
+type LoginAttempt struct {
+	ID        int64     `orm:"pk;auto;column(id)" json:"id"`
+	Username  string    `orm:"column(username);size(128)" json:"username"`
+	IP        string    `orm:"column(ip);size(64)" json:"ip"`
+	Success   bool      `orm:"column(success)" json:"success"`
+	Cleared   bool      `orm:"column(cleared);default(false)" json:"cleared"`
+	CreatedAt time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (a *LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+// EnsureLoginAttemptsTable creates the login_attempts table if it doesn't exist
+func EnsureLoginAttemptsTable() error {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		username VARCHAR(128) NOT NULL,
+		ip VARCHAR(64) NOT NULL,
+		success BOOLEAN NOT NULL,
+		cleared BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON login_attempts (username, created_at)`,
+		`CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at)`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeLoginName makes attempt tracking case-insensitive so that
+// changing the case of a username doesn't reset the counter
+func normalizeLoginName(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// RecordLoginAttempt stores the outcome of a login attempt
+func RecordLoginAttempt(username, ip string, success bool) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`INSERT INTO login_attempts (username, ip, success, created_at)
+					 VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
+		normalizeLoginName(username), ip, success).Exec()
+	if err != nil {
+		return err
+	}
+
+	// Attempts older than a day no longer affect throttling
+	_, err = o.Raw(`DELETE FROM login_attempts WHERE created_at < ?`, time.Now().Add(-24*time.Hour)).Exec()
+	return err
+}
+
+// LoginRetryAfter returns how long the client must wait before another login
+// attempt for the username from the IP is allowed. Zero means no wait.
+//
+// Once an account or IP reaches the delay threshold of recent failures, each
+// further failure doubles the wait, up to LoginMaxDelay. At the lockout
+// threshold the account or IP is locked for LoginLockoutDuration.
+func LoginRetryAfter(username, ip string) (time.Duration, error) {
+	accountWait, err := retryAfter("username", normalizeLoginName(username), config.LoginMaxFailures, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// A success doesn't reset the IP's count, or signing in to one's own
+	// account between guesses would lift the limit on spraying others
+	ipWait, err := retryAfter("ip", ip, config.LoginIPMaxFailures, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// retryAfter computes the wait for one throttling key (username or IP) from
+// its failures within the window. With resetOnSuccess, only the failures
+// since the key's last successful login count.
+func retryAfter(column, value string, maxFailures int, resetOnSuccess bool) (time.Duration, error) {
+	var stats struct {
+		Failures    int
+		LastFailure time.Time
+	}
+
+	since := time.Now().Add(-config.LoginWindow)
+	start := `?`
+	args := []interface{}{since, value, since}
+	if resetOnSuccess {
+		start = `GREATEST(?, (
+					SELECT COALESCE(MAX(created_at), ?) FROM login_attempts WHERE ` + column + ` = ? AND success = TRUE
+				  ))`
+		args = append(args, since, value)
+	}
+	o := orm.NewOrm()
+	err := o.Raw(`SELECT COUNT(*) AS failures, COALESCE(MAX(created_at), ?) AS last_failure
+				  FROM login_attempts
+				  WHERE `+column+` = ? AND success = FALSE AND cleared = FALSE AND created_at > `+start,
+		args...).QueryRow(&stats)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	switch {
+	case stats.Failures >= maxFailures:
+		wait = config.LoginLockoutDuration
+	case stats.Failures >= config.LoginDelayThreshold:
+		wait = loginDelay(stats.Failures)
+	default:
+		return 0, nil
+	}
+
+	remaining := time.Until(stats.LastFailure.Add(wait))
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// maxLoginDelayShift caps the doubling in loginDelay. 2^30 seconds is far
+// above any LoginMaxDelay, and a larger shift would overflow time.Duration.
+const maxLoginDelayShift = 30
+
+// loginDelay is the wait after a number of failures at or above the delay
+// threshold: a second, doubling with each further failure, up to
+// LoginMaxDelay
+func loginDelay(failures int) time.Duration {
+	shift := failures - config.LoginDelayThreshold
+	if shift > maxLoginDelayShift {
+		shift = maxLoginDelayShift
+	}
+	wait := time.Second << uint(shift)
+	if wait > config.LoginMaxDelay {
+		wait = config.LoginMaxDelay
+	}
+	return wait
+}
+
+// UnlockLogin clears the failed attempts recorded for a username so that it
+// can sign in again immediately
+func UnlockLogin(username string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`UPDATE login_attempts SET cleared = TRUE WHERE username = ? AND success = FALSE`,
+		normalizeLoginName(username)).Exec()
+	return err
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"cbc-backend/config"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginDelay(t *testing.T) {
+	config.LoginDelayThreshold = 3
+	config.LoginMaxDelay = 30 * time.Second
+
+	assert.Equal(t, time.Second, loginDelay(3))
+	assert.Equal(t, 4*time.Second, loginDelay(5))
+	assert.Equal(t, 30*time.Second, loginDelay(10))
+
+	// Far past the threshold the delay stays at the maximum instead of
+	// overflowing to zero
+	assert.Equal(t, 30*time.Second, loginDelay(37))
+	assert.Equal(t, 30*time.Second, loginDelay(1000))
+
+	config.LoginMaxDelay = math.MaxInt64
+	assert.Equal(t, time.Second<<maxLoginDelayShift, loginDelay(100))
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"cbc-backend/utils"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// RecoveryCodeCount is how many one-time recovery codes are issued when
+// two-factor authentication is enabled
+const RecoveryCodeCount = 10
+
+var (
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already uses 2FA
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrMFANotEnabled is returned when 2FA is required but not set up
+	ErrMFANotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrInvalidMFACode is returned for wrong, reused or expired codes
+	ErrInvalidMFACode = errors.New("invalid two-factor authentication code")
+)
+
+// UserMFA holds a user's TOTP secret. EnabledAt is set once the user has
+// proven they can generate codes; until then the enrollment is pending.
+type UserMFA struct {
+	UserID       string     `orm:"pk;column(user_id);size(36)" json:"user_id"`
+	Secret       string     `orm:"column(secret);size(64)" json:"-"`
+	EnabledAt    *time.Time `orm:"column(enabled_at);null;type(timestamp with time zone)" json:"enabled_at"`
+	LastUsedStep int64      `orm:"column(last_used_step);default(0)" json:"-"`
+	CreatedAt    time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (m *UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// MFARecoveryCode is a hashed single-use code that can replace a TOTP code
+type MFARecoveryCode struct {
+	ID        int64      `orm:"pk;auto;column(id)" json:"id"`
+	UserID    string     `orm:"column(user_id);size(36)" json:"user_id"`
+	CodeHash  string     `orm:"column(code_hash);size(64)" json:"-"`
+	UsedAt    *time.Time `orm:"column(used_at);null;type(timestamp with time zone)" json:"used_at"`
+	CreatedAt time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (c *MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// EnsureMFATables creates the user_mfa and mfa_recovery_codes tables if they don't exist
+func EnsureMFATables() error {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS user_mfa (
+		user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		secret VARCHAR(64) NOT NULL,
+		enabled_at TIMESTAMP WITH TIME ZONE,
+		last_used_step BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id BIGSERIAL PRIMARY KEY,
+		user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash VARCHAR(64) NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id)`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartMFAEnrollment generates a new TOTP secret for the user. It replaces
+// any pending enrollment but refuses to touch an enabled one.
+func StartMFAEnrollment(userID string) (string, error) {
+	enabled, err := IsMFAEnabled(userID)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	o := orm.NewOrm()
+	_, err = o.Raw(`INSERT INTO user_mfa (user_id, secret, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+					 ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0,
+					 created_at = CURRENT_TIMESTAMP`,
+		userID, secret).Exec()
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmMFAEnrollment enables 2FA once the user proves their authenticator
+// works, and returns freshly generated recovery codes. The plaintext codes
+// are only ever returned here.
+func ConfirmMFAEnrollment(userID, code string) ([]string, error) {
+	var codes []string
+
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var mfa UserMFA
+		if err := txOrm.Raw(`SELECT * FROM user_mfa WHERE user_id = ? FOR UPDATE`, userID).QueryRow(&mfa); err != nil {
+			return ErrMFANotEnabled
+		}
+		if mfa.EnabledAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+
+		step, ok := utils.ValidateTOTP(mfa.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		_, err := txOrm.Raw(`UPDATE user_mfa SET enabled_at = CURRENT_TIMESTAMP, last_used_step = ? WHERE user_id = ?`,
+			int64(step), userID).Exec()
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(txOrm, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores new ones
+func replaceRecoveryCodes(o orm.QueryExecutor, userID string) ([]string, error) {
+	if _, err := o.Raw(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID).Exec(); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+
+		_, err = o.Raw(`INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+						VALUES (?, ?, CURRENT_TIMESTAMP)`,
+			userID, hashToken(normalizeRecoveryCode(code))).Exec()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx using an
+// alphabet without easily confused characters
+func newRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	// Largest multiple of len(alphabet) that fits in a byte, so that
+	// rejecting bytes above it keeps every character equally likely
+	limit := 256 - 256%len(alphabet)
+
+	code := make([]byte, 0, 10)
+	buf := make([]byte, 16)
+	for len(code) < cap(code) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < cap(code) {
+				code = append(code, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return fmt.Sprintf("%s-%s", code[:5], code[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// IsMFAEnabled reports whether the user has completed 2FA enrollment
+func IsMFAEnabled(userID string) (bool, error) {
+	o := orm.NewOrm()
+	var count int64
+	err := o.Raw(`SELECT COUNT(*) FROM user_mfa WHERE user_id = ? AND enabled_at IS NOT NULL`, userID).QueryRow(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// VerifyMFACode checks a TOTP code, or failing that a recovery code, for a
+// user with 2FA enabled. TOTP codes can't be replayed within their window and
+// recovery codes work once.
+func VerifyMFACode(userID, code string) error {
+	o := orm.NewOrm()
+	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var mfa UserMFA
+		err := txOrm.Raw(`SELECT * FROM user_mfa WHERE user_id = ? AND enabled_at IS NOT NULL FOR UPDATE`, userID).
+			QueryRow(&mfa)
+		if err != nil {
+			return ErrMFANotEnabled
+		}
+
+		if step, ok := utils.ValidateTOTP(mfa.Secret, code, time.Now()); ok {
+			if int64(step) <= mfa.LastUsedStep {
+				return ErrInvalidMFACode
+			}
+			_, err := txOrm.Raw(`UPDATE user_mfa SET last_used_step = ? WHERE user_id = ?`, int64(step), userID).Exec()
+			return err
+		}
+
+		res, err := txOrm.Raw(`UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+							   WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+			userID, hashToken(normalizeRecoveryCode(code))).Exec()
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	})
+}
+
+// DisableMFA removes the user's TOTP secret and recovery codes
+func DisableMFA(userID string) error {
+	o := orm.NewOrm()
+	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		if _, err := txOrm.Raw(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID).Exec(); err != nil {
+			return err
+		}
+		_, err := txOrm.Raw(`DELETE FROM user_mfa WHERE user_id = ?`, userID).Exec()
+		return err
+	})
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// TokenPurpose binds a one-time token to the single action it authorizes
+type TokenPurpose string
+
+// One-time token purposes
+const (
+	TokenPasswordReset     TokenPurpose = "password_reset"
+	TokenEmailVerification TokenPurpose = "email_verification"
+	TokenInvitation        TokenPurpose = "invitation"
+	TokenMagicLink         TokenPurpose = "magic_link"
+)
+
+// ErrInvalidOneTimeToken is returned for unknown, used or expired tokens, and
+// for tokens issued for another purpose
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// OneTimeToken is a single-use token emailed in a link, such as a password
+// reset. Only the hash of the token is stored.
+type OneTimeToken struct {
+	ID      int64        `orm:"pk;auto;column(id)" json:"id"`
+	Purpose TokenPurpose `orm:"column(purpose);size(32)" json:"purpose"`
+	// UserID is the user the token acts for, if any; invitations may be for
+	// people without an account
+	UserID *string `orm:"column(user_id);size(36);null" json:"user_id"`
+	// Subject is what the token is about beyond the user, e.g. the invited
+	// email address
+	Subject   string     `orm:"column(subject);size(255)" json:"subject"`
+	TokenHash string     `orm:"column(token_hash);size(64);unique" json:"-"`
+	ExpiresAt time.Time  `orm:"column(expires_at);type(timestamp with time zone)" json:"expires_at"`
+	UsedAt    *time.Time `orm:"column(used_at);null;type(timestamp with time zone)" json:"used_at"`
+	CreatedAt time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (t *OneTimeToken) TableName() string {
+	return "one_time_tokens"
+}
+
+// EnsureOneTimeTokensTable creates the one_time_tokens table if it doesn't
+// exist, and moves over tokens from the password_resets and
+// email_verifications tables it replaces
+func EnsureOneTimeTokensTable() error {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS one_time_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		purpose VARCHAR(32) NOT NULL,
+		user_id VARCHAR(36) REFERENCES users(id) ON DELETE CASCADE,
+		subject VARCHAR(255) NOT NULL DEFAULT '',
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX IF NOT EXISTS one_time_tokens_user_idx ON one_time_tokens (user_id, purpose)`,
+	}
+
+	// Reset tokens were stored in plaintext; only their hashes move over
+	legacy := []struct {
+		table      string
+		statements []string
+	}{
+		{"password_resets", []string{
+			`INSERT INTO one_time_tokens (purpose, user_id, token_hash, expires_at, used_at, created_at)
+			 SELECT 'password_reset', user_id, encode(sha256(convert_to(token, 'UTF8')), 'hex'), expires_at,
+			        CASE WHEN used THEN created_at END, created_at
+			 FROM password_resets WHERE user_id IS NOT NULL
+			 ON CONFLICT (token_hash) DO NOTHING`,
+			`DROP TABLE password_resets`,
+		}},
+		{"email_verifications", []string{
+			`INSERT INTO one_time_tokens (purpose, user_id, token_hash, expires_at, used_at, created_at)
+			 SELECT 'email_verification', user_id, token_hash, expires_at, used_at, created_at
+			 FROM email_verifications
+			 ON CONFLICT (token_hash) DO NOTHING`,
+			`DROP TABLE email_verifications`,
+		}},
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	for _, table := range legacy {
+		var exists bool
+		if err := o.Raw(`SELECT to_regclass(?) IS NOT NULL`, table.table).QueryRow(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+			for _, sql := range table.statements {
+				if _, err := txOrm.Raw(sql).Exec(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s: %v", table.table, err)
+		}
+	}
+	return nil
+}
+
+// IssueOneTimeToken creates a token for the purpose that expires after ttl
+// and returns it. Earlier unused tokens with the same purpose, user and
+// subject stop working so that only the latest link is valid.
+func IssueOneTimeToken(purpose TokenPurpose, userID, subject string, ttl time.Duration) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	var user *string
+	if userID != "" {
+		user = &userID
+	}
+
+	o := orm.NewOrm()
+	err = o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		_, err := txOrm.Raw(`UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP
+							 WHERE purpose = ? AND user_id IS NOT DISTINCT FROM ? AND subject = ? AND used_at IS NULL`,
+			purpose, user, subject).Exec()
+		if err != nil {
+			return err
+		}
+
+		_, err = txOrm.Raw(`INSERT INTO one_time_tokens (purpose, user_id, subject, token_hash, expires_at, created_at)
+							VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+			purpose, user, subject, hashToken(token), time.Now().Add(ttl)).Exec()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeOneTimeToken uses up a token for the purpose and runs fn in the
+// same transaction. If fn fails, nothing is committed and the token stays
+// valid. It returns ErrInvalidOneTimeToken if the token can't be used.
+func ConsumeOneTimeToken(purpose TokenPurpose, token string, fn func(txOrm orm.TxOrmer, t *OneTimeToken) error) error {
+	o := orm.NewOrm()
+	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var stored OneTimeToken
+		err := txOrm.Raw(`SELECT * FROM one_time_tokens WHERE token_hash = ? AND purpose = ? FOR UPDATE`,
+			hashToken(token), purpose).QueryRow(&stored)
+		if err != nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+			return ErrInvalidOneTimeToken
+		}
+
+		if _, err := txOrm.Raw(`UPDATE one_time_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ?`, stored.ID).Exec(); err != nil {
+			return err
+		}
+		return fn(txOrm, &stored)
+	})
+}
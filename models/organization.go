@@ -0,0 +1,548 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/google/uuid"
+)
+
+// OrgRole is a member's role within an organization, such as a school
+type OrgRole string
+
+const (
+	OrgOwner   OrgRole = "owner"
+	OrgAdmin   OrgRole = "admin"
+	OrgTeacher OrgRole = "teacher"
+)
+
+// orgRoleRanks orders the roles; each role can do everything the roles
+// below it can
+var orgRoleRanks = map[OrgRole]int{
+	OrgTeacher: 1,
+	OrgAdmin:   2,
+	OrgOwner:   3,
+}
+
+// Valid reports whether r is one of the known organization roles
+func (r OrgRole) Valid() bool {
+	_, ok := orgRoleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether the role ranks the same as or above min
+func (r OrgRole) AtLeast(min OrgRole) bool {
+	return r.Valid() && orgRoleRanks[r] >= orgRoleRanks[min]
+}
+
+// ParseOrgRole converts a string to an OrgRole, rejecting unknown values
+func ParseOrgRole(s string) (OrgRole, error) {
+	role := OrgRole(strings.ToLower(strings.TrimSpace(s)))
+	if !role.Valid() {
+		return "", fmt.Errorf("invalid organization role %q", s)
+	}
+	return role, nil
+}
+
+// Invitation statuses
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+var (
+	// ErrLastOwner is returned when a change would leave an organization
+	// without an owner
+	ErrLastOwner = errors.New("cannot remove the last owner of the organization")
+	// ErrNoOrgSuccessor is returned when erasing the only owner of an
+	// organization that has no admin to take over
+	ErrNoOrgSuccessor = errors.New("you are the only owner of an organization with no admin to take over; " +
+		"transfer its ownership or delete it first")
+	// ErrAlreadyMember is returned when inviting someone who is already a
+	// member
+	ErrAlreadyMember = errors.New("user is already a member of the organization")
+	// ErrInvitationEmailMismatch is returned when an invitation is accepted
+	// by an account with a different email address than the one invited
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+// Organization is a school or other group whose members share content
+type Organization struct {
+	ID        string    `orm:"pk;size(36);column(id)" json:"id"`
+	Name      string    `orm:"column(name);size(255)" json:"name"`
+	CreatedBy string    `orm:"column(created_by);size(36);null" json:"created_by"`
+	CreatedAt time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (o *Organization) TableName() string {
+	return "organizations"
+}
+
+// OrganizationMember is a user's membership of an organization
+type OrganizationMember struct {
+	ID             int64     `orm:"pk;auto;column(id)" json:"-"`
+	OrganizationID string    `orm:"column(organization_id);size(36)" json:"organization_id"`
+	UserID         string    `orm:"column(user_id);size(36)" json:"user_id"`
+	Role           OrgRole   `orm:"column(role);size(16)" json:"role"`
+	CreatedAt      time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"joined_at"`
+}
+
+// TableName specifies the database table name
+func (m *OrganizationMember) TableName() string {
+	return "organization_members"
+}
+
+// OrganizationInvitation invites an email address to join an organization.
+// The emailed token is a one-time token whose subject is the invitation ID.
+type OrganizationInvitation struct {
+	ID             string     `orm:"pk;size(36);column(id)" json:"id"`
+	OrganizationID string     `orm:"column(organization_id);size(36)" json:"organization_id"`
+	Email          string     `orm:"column(email);size(255)" json:"email"`
+	Role           OrgRole    `orm:"column(role);size(16)" json:"role"`
+	InvitedBy      string     `orm:"column(invited_by);size(36);null" json:"invited_by"`
+	Status         string     `orm:"column(status);size(16)" json:"status"`
+	RespondedAt    *time.Time `orm:"column(responded_at);null;type(timestamp with time zone)" json:"responded_at"`
+	CreatedAt      time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (i *OrganizationInvitation) TableName() string {
+	return "organization_invitations"
+}
+
+// UserOrganization is an organization together with a member's role in it
+type UserOrganization struct {
+	ID        string    `orm:"column(id)" json:"id"`
+	Name      string    `orm:"column(name)" json:"name"`
+	Role      OrgRole   `orm:"column(role)" json:"role"`
+	JoinedAt  time.Time `orm:"column(joined_at)" json:"joined_at"`
+	CreatedAt time.Time `orm:"column(created_at)" json:"created_at"`
+}
+
+// MemberInfo is a member of an organization as shown in member listings
+type MemberInfo struct {
+	UserID   string    `orm:"column(user_id)" json:"user_id"`
+	Username string    `orm:"column(username)" json:"username"`
+	Email    string    `orm:"column(email)" json:"email"`
+	Role     OrgRole   `orm:"column(role)" json:"role"`
+	JoinedAt time.Time `orm:"column(joined_at)" json:"joined_at"`
+}
+
+// EnsureOrganizationTables creates the organizations, organization_members
+// and organization_invitations tables if they don't exist
+func EnsureOrganizationTables() error {
+	roles := "'" + string(OrgOwner) + "', '" + string(OrgAdmin) + "', '" + string(OrgTeacher) + "'"
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS organizations (
+		id VARCHAR(36) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		id BIGSERIAL PRIMARY KEY,
+		organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+		user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role VARCHAR(16) NOT NULL CHECK (role IN (` + roles + `)),
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (organization_id, user_id)
+	)`,
+		`CREATE INDEX IF NOT EXISTS organization_members_user_idx ON organization_members (user_id)`, `
+	CREATE TABLE IF NOT EXISTS organization_invitations (
+		id VARCHAR(36) PRIMARY KEY,
+		organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL,
+		role VARCHAR(16) NOT NULL CHECK (role IN (` + roles + `)),
+		invited_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		responded_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+		// At most one pending invitation per address and organization
+		`CREATE UNIQUE INDEX IF NOT EXISTS organization_invitations_pending_idx
+		 ON organization_invitations (organization_id, LOWER(email)) WHERE status = 'pending'`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateOrganization creates an organization with the user as its owner
+func CreateOrganization(name, ownerID string) (*Organization, error) {
+	org := &Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedBy: ownerID,
+	}
+
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		_, err := txOrm.Raw(`INSERT INTO organizations (id, name, created_by, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
+			org.ID, org.Name, ownerID).Exec()
+		if err != nil {
+			return err
+		}
+		_, err = txOrm.Raw(`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+							VALUES (?, ?, ?, CURRENT_TIMESTAMP)`, org.ID, ownerID, OrgOwner).Exec()
+		if err != nil {
+			return err
+		}
+		return txOrm.Raw(`SELECT * FROM organizations WHERE id = ?`, org.ID).QueryRow(org)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// GetOrganization retrieves an organization by ID
+func GetOrganization(id string) (*Organization, error) {
+	o := orm.NewOrm()
+	org := &Organization{}
+	err := o.Raw(`SELECT * FROM organizations WHERE id = ?`, id).QueryRow(org)
+	return org, err
+}
+
+// RenameOrganization changes an organization's name
+func RenameOrganization(id, name string) (*Organization, error) {
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE organizations SET name = ? WHERE id = ?`, name, id).Exec()
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, orm.ErrNoRows
+	}
+	return GetOrganization(id)
+}
+
+// DeleteOrganization deletes an organization with its memberships and
+// invitations. Jobs posted on its behalf are kept as their poster's own.
+func DeleteOrganization(id string) error {
+	o := orm.NewOrm()
+	res, err := o.Raw(`DELETE FROM organizations WHERE id = ?`, id).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// ListUserOrganizations returns the organizations the user belongs to
+func ListUserOrganizations(userID string) ([]UserOrganization, error) {
+	o := orm.NewOrm()
+	orgs := []UserOrganization{}
+	_, err := o.Raw(`SELECT org.id, org.name, m.role, m.created_at AS joined_at, org.created_at
+					 FROM organization_members m JOIN organizations org ON org.id = m.organization_id
+					 WHERE m.user_id = ? ORDER BY org.name`, userID).QueryRows(&orgs)
+	return orgs, err
+}
+
+// GetMembership returns the user's membership of the organization, or
+// orm.ErrNoRows if they aren't a member
+func GetMembership(orgID, userID string) (*OrganizationMember, error) {
+	o := orm.NewOrm()
+	member := &OrganizationMember{}
+	err := o.Raw(`SELECT * FROM organization_members WHERE organization_id = ? AND user_id = ?`, orgID, userID).
+		QueryRow(member)
+	return member, err
+}
+
+// ListMembers returns the organization's members, owners first. Users in
+// the trash aren't listed.
+func ListMembers(orgID string) ([]MemberInfo, error) {
+	o := orm.NewOrm()
+	members := []MemberInfo{}
+	_, err := o.Raw(`SELECT m.user_id, u.username, u.email, m.role, m.created_at AS joined_at
+					 FROM organization_members m JOIN users u ON u.id = m.user_id
+					 WHERE m.organization_id = ? AND u.deleted_at IS NULL
+					 ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, u.username`, orgID).
+		QueryRows(&members)
+	return members, err
+}
+
+// SetMemberRole changes a member's role. The last owner can't be demoted.
+func SetMemberRole(orgID, userID string, role OrgRole) (*OrganizationMember, error) {
+	var member OrganizationMember
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		err := txOrm.Raw(`SELECT * FROM organization_members WHERE organization_id = ? AND user_id = ? FOR UPDATE`,
+			orgID, userID).QueryRow(&member)
+		if err != nil {
+			return err
+		}
+		if member.Role == OrgOwner && role != OrgOwner {
+			if err := ensureOtherOwner(txOrm, orgID, userID); err != nil {
+				return err
+			}
+		}
+
+		member.Role = role
+		_, err = txOrm.Raw(`UPDATE organization_members SET role = ? WHERE id = ?`, role, member.ID).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember removes a user from the organization. The last owner can't
+// be removed.
+func RemoveMember(orgID, userID string) error {
+	o := orm.NewOrm()
+	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var member OrganizationMember
+		err := txOrm.Raw(`SELECT * FROM organization_members WHERE organization_id = ? AND user_id = ? FOR UPDATE`,
+			orgID, userID).QueryRow(&member)
+		if err != nil {
+			return err
+		}
+		if member.Role == OrgOwner {
+			if err := ensureOtherOwner(txOrm, orgID, userID); err != nil {
+				return err
+			}
+		}
+
+		_, err = txOrm.Raw(`DELETE FROM organization_members WHERE id = ?`, member.ID).Exec()
+		return err
+	})
+}
+
+// ensureOtherOwner returns ErrLastOwner unless the organization has an
+// owner other than the user. The owners are locked so that two concurrent
+// changes can't remove both.
+func ensureOtherOwner(q orm.QueryExecutor, orgID, userID string) error {
+	var others []string
+	_, err := q.Raw(`SELECT user_id FROM organization_members
+					 WHERE organization_id = ? AND role = ? AND user_id <> ? FOR UPDATE`,
+		orgID, OrgOwner, userID).QueryRows(&others)
+	if err != nil {
+		return err
+	}
+	if len(others) == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// handOverOwnership makes the longest-standing admin an owner of each
+// organization that the user is the only owner of, so that erasing the user
+// doesn't leave it without one. It returns ErrNoOrgSuccessor if one of them
+// has no admin; with checkOnly set it only checks.
+func handOverOwnership(q orm.QueryExecutor, userID string, checkOnly bool) error {
+	var orgIDs []string
+	_, err := q.Raw(`SELECT m.organization_id FROM organization_members m
+					 WHERE m.user_id = ? AND m.role = ? AND NOT EXISTS (
+						 SELECT 1 FROM organization_members other
+						 WHERE other.organization_id = m.organization_id AND other.role = ? AND other.user_id <> m.user_id)
+					 FOR UPDATE`, userID, OrgOwner, OrgOwner).QueryRows(&orgIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, orgID := range orgIDs {
+		var successors []int64
+		_, err := q.Raw(`SELECT m.id FROM organization_members m JOIN users u ON u.id = m.user_id
+						 WHERE m.organization_id = ? AND m.role = ?
+						   AND u.deleted_at IS NULL AND u.erasure_scheduled_at IS NULL
+						 ORDER BY m.created_at, m.id LIMIT 1 FOR UPDATE OF m`, orgID, OrgAdmin).QueryRows(&successors)
+		if err != nil {
+			return err
+		}
+		if len(successors) == 0 {
+			return ErrNoOrgSuccessor
+		}
+		if checkOnly {
+			continue
+		}
+		if _, err := q.Raw(`UPDATE organization_members SET role = ? WHERE id = ?`, OrgOwner, successors[0]).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InviteToOrganization invites the email address to join the organization
+// with the role and returns the invitation with the token to email. Inviting
+// an address again replaces its pending invitation, and its earlier links
+// stop working.
+func InviteToOrganization(orgID, email string, role OrgRole, invitedBy string, ttl time.Duration) (*OrganizationInvitation, string, error) {
+	email = strings.TrimSpace(email)
+
+	var invitation OrganizationInvitation
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var members int64
+		err := txOrm.Raw(`SELECT COUNT(*) FROM organization_members m JOIN users u ON u.id = m.user_id
+						  WHERE m.organization_id = ? AND LOWER(u.email) = LOWER(?)`, orgID, email).QueryRow(&members)
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			return ErrAlreadyMember
+		}
+
+		err = txOrm.Raw(`SELECT * FROM organization_invitations
+						 WHERE organization_id = ? AND LOWER(email) = LOWER(?) AND status = ? FOR UPDATE`,
+			orgID, email, InvitationPending).QueryRow(&invitation)
+		switch {
+		case err == nil:
+			_, err = txOrm.Raw(`UPDATE organization_invitations SET role = ?, invited_by = ?, created_at = CURRENT_TIMESTAMP
+								WHERE id = ?`, role, invitedBy, invitation.ID).Exec()
+		case errors.Is(err, orm.ErrNoRows):
+			_, err = txOrm.Raw(`INSERT INTO organization_invitations (id, organization_id, email, role, invited_by, status, created_at)
+								VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+				uuid.New().String(), orgID, email, role, invitedBy, InvitationPending).Exec()
+		}
+		if err != nil {
+			return err
+		}
+		return txOrm.Raw(`SELECT * FROM organization_invitations
+						  WHERE organization_id = ? AND LOWER(email) = LOWER(?) AND status = ?`,
+			orgID, email, InvitationPending).QueryRow(&invitation)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := IssueOneTimeToken(TokenInvitation, "", invitation.ID, ttl)
+	if err != nil {
+		return nil, "", err
+	}
+	return &invitation, token, nil
+}
+
+// ListInvitations returns the organization's pending invitations
+func ListInvitations(orgID string) ([]OrganizationInvitation, error) {
+	o := orm.NewOrm()
+	invitations := []OrganizationInvitation{}
+	_, err := o.Raw(`SELECT * FROM organization_invitations WHERE organization_id = ? AND status = ?
+					 ORDER BY created_at DESC`, orgID, InvitationPending).QueryRows(&invitations)
+	return invitations, err
+}
+
+// RevokeInvitation withdraws a pending invitation so that its link stops
+// working. It returns orm.ErrNoRows if there is no such pending invitation.
+func RevokeInvitation(orgID, invitationID string) error {
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE organization_invitations SET status = ?, responded_at = CURRENT_TIMESTAMP
+					   WHERE id = ? AND organization_id = ? AND status = ?`,
+		InvitationRevoked, invitationID, orgID, InvitationPending).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// AcceptInvitation adds the user to the organization they were invited to.
+// The user's email must match the invited address; otherwise the link stays
+// valid for the right account. A user who is already a member keeps their
+// role.
+func AcceptInvitation(token string, user *User) (*OrganizationInvitation, error) {
+	return respondToInvitation(token, InvitationAccepted, func(txOrm orm.TxOrmer, invitation *OrganizationInvitation) error {
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			return ErrInvitationEmailMismatch
+		}
+		_, err := txOrm.Raw(`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+							 VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+							 ON CONFLICT (organization_id, user_id) DO NOTHING`,
+			invitation.OrganizationID, user.ID, invitation.Role).Exec()
+		return err
+	})
+}
+
+// DeclineInvitation turns down an invitation. No account is needed.
+func DeclineInvitation(token string) (*OrganizationInvitation, error) {
+	return respondToInvitation(token, InvitationDeclined, nil)
+}
+
+// respondToInvitation consumes an invitation token, runs fn if given and
+// sets the invitation's status, all in one transaction
+func respondToInvitation(token, status string, fn func(txOrm orm.TxOrmer, invitation *OrganizationInvitation) error) (*OrganizationInvitation, error) {
+	var invitation OrganizationInvitation
+	err := ConsumeOneTimeToken(TokenInvitation, token, func(txOrm orm.TxOrmer, t *OneTimeToken) error {
+		err := txOrm.Raw(`SELECT * FROM organization_invitations WHERE id = ? AND status = ? FOR UPDATE`,
+			t.Subject, InvitationPending).QueryRow(&invitation)
+		if err != nil {
+			return ErrInvalidOneTimeToken
+		}
+		if fn != nil {
+			if err := fn(txOrm, &invitation); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		invitation.Status = status
+		invitation.RespondedAt = &now
+		_, err = txOrm.Raw(`UPDATE organization_invitations SET status = ?, responded_at = ? WHERE id = ?`,
+			status, now, invitation.ID).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// ListOrganizationUploads returns a page of the uploads of the
+// organization's current members, newest first, together with the total
+// number of uploads
+func ListOrganizationUploads(orgID string, page, pageSize int) ([]Upload, int64, error) {
+	const from = `FROM uploads up JOIN organization_members m ON m.user_id = up.user_id
+				  WHERE m.organization_id = ? AND up.deleted_at IS NULL`
+
+	o := orm.NewOrm()
+	var total int64
+	if err := o.Raw(`SELECT COUNT(*) `+from, orgID).QueryRow(&total); err != nil {
+		return nil, 0, err
+	}
+
+	uploads := []Upload{}
+	_, err := o.Raw(`SELECT up.* `+from+` ORDER BY up.created_at DESC LIMIT ? OFFSET ?`,
+		orgID, pageSize, (page-1)*pageSize).QueryRows(&uploads)
+	if err != nil {
+		return nil, 0, err
+	}
+	return uploads, total, nil
+}
+
+// ListOrganizationJobs returns a page of the jobs posted on the
+// organization's behalf or by its current members, newest first, together
+// with the total number of jobs
+func ListOrganizationJobs(orgID string, page, pageSize int) ([]Job, int64, error) {
+	const from = `FROM jobs WHERE deleted_at IS NULL AND (organization_id = ? OR posted_by IN (
+				  SELECT user_id FROM organization_members WHERE organization_id = ?))`
+
+	o := orm.NewOrm()
+	var total int64
+	if err := o.Raw(`SELECT COUNT(*) `+from, orgID, orgID).QueryRow(&total); err != nil {
+		return nil, 0, err
+	}
+
+	jobs := []Job{}
+	_, err := o.Raw(`SELECT * `+from+` ORDER BY created_at DESC LIMIT ? OFFSET ?`,
+		orgID, orgID, pageSize, (page-1)*pageSize).QueryRows(&jobs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
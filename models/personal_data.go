@@ -0,0 +1,197 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// PersonalDataFormatVersion is bumped whenever the export layout changes
+const PersonalDataFormatVersion = 1
+
+// PersonalData is everything held about a user, as included in their
+// personal data export
+type PersonalData struct {
+	Account        map[string]interface{} // The full profile, as the user sees it
+	Identities     []UserIdentity
+	Organizations  []UserOrganization
+	Devices        []Device
+	Uploads        []Upload
+	Jobs           []Job
+	PasswordResets []PasswordResetRecord
+	AuditEvents    []AuditEvent // Events by or about the user
+}
+
+// PasswordResetRecord is a password reset request without its token
+type PasswordResetRecord struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+}
+
+// ExportManifest describes the files in a personal data export
+type ExportManifest struct {
+	FormatVersion int          `json:"format_version"`
+	GeneratedAt   time.Time    `json:"generated_at"`
+	UserID        string       `json:"user_id"`
+	Files         []ExportFile `json:"files"`
+	// MissingFiles lists uploads whose file couldn't be read
+	MissingFiles []string `json:"missing_files,omitempty"`
+}
+
+// ExportFile is a file in a personal data export
+type ExportFile struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// CollectPersonalData loads everything held about the user
+func CollectPersonalData(userID string) (*PersonalData, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := GetUserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &PersonalData{
+		Account:        ProfileView(user, profile, user.ID, user.Role),
+		Identities:     []UserIdentity{},
+		Organizations:  []UserOrganization{},
+		Devices:        []Device{},
+		Uploads:        []Upload{},
+		Jobs:           []Job{},
+		PasswordResets: []PasswordResetRecord{},
+		AuditEvents:    []AuditEvent{},
+	}
+
+	o := orm.NewOrm()
+	queries := []struct {
+		sql  string
+		args []interface{}
+		dest interface{}
+	}{
+		{`SELECT * FROM user_identities WHERE user_id = ? ORDER BY created_at`, []interface{}{userID}, &data.Identities},
+		{`SELECT org.id, org.name, m.role, m.created_at AS joined_at, org.created_at
+		  FROM organization_members m JOIN organizations org ON org.id = m.organization_id
+		  WHERE m.user_id = ? ORDER BY m.created_at`, []interface{}{userID}, &data.Organizations},
+		{`SELECT * FROM user_devices WHERE user_id = ? ORDER BY created_at`, []interface{}{userID}, &data.Devices},
+		{`SELECT * FROM uploads WHERE user_id = ? ORDER BY created_at`, []interface{}{userID}, &data.Uploads},
+		{`SELECT * FROM jobs WHERE posted_by = ? ORDER BY created_at`, []interface{}{userID}, &data.Jobs},
+		{`SELECT created_at, expires_at, used_at IS NOT NULL AS used FROM one_time_tokens
+		  WHERE user_id = ? AND purpose = ? ORDER BY created_at`,
+			[]interface{}{userID, TokenPasswordReset}, &data.PasswordResets},
+		{`SELECT * FROM audit_events WHERE actor_id = ? OR (target_type = 'user' AND target_id = ?) ORDER BY id`,
+			[]interface{}{userID, userID}, &data.AuditEvents},
+	}
+	for _, q := range queries {
+		if _, err := o.Raw(q.sql, q.args...).QueryRows(q.dest); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// WritePersonalDataArchive writes the data as a ZIP of JSON files plus the
+// uploaded files, with manifest.json listing every file and its checksum.
+// Uploads whose file is missing are listed in the manifest instead.
+func WritePersonalDataArchive(w io.Writer, userID string, data *PersonalData) error {
+	archive := zip.NewWriter(w)
+	manifest := ExportManifest{
+		FormatVersion: PersonalDataFormatVersion,
+		GeneratedAt:   time.Now().UTC(),
+		UserID:        userID,
+		Files:         []ExportFile{},
+	}
+
+	add := func(name, description string, r io.Reader) error {
+		entry, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(entry, hash), r)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ExportFile{
+			Path:        name,
+			Description: description,
+			Size:        size,
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		})
+		return nil
+	}
+	addJSON := func(name, description string, v interface{}) error {
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, description, bytes.NewReader(content))
+	}
+
+	documents := []struct {
+		name, description string
+		value             interface{}
+	}{
+		{"account.json", "Your account and profile", data.Account},
+		{"identities.json", "Accounts at identity providers linked for sign in", data.Identities},
+		{"organizations.json", "Organizations you are a member of", data.Organizations},
+		{"devices.json", "Devices you are signed in on", data.Devices},
+		{"uploads.json", "Files you uploaded; the files are in uploads/", data.Uploads},
+		{"jobs.json", "Jobs you posted", data.Jobs},
+		{"password_resets.json", "Password reset requests", data.PasswordResets},
+		{"audit_events.json", "Security log events about you or your actions", data.AuditEvents},
+	}
+	for _, doc := range documents {
+		if err := addJSON(doc.name, doc.description, doc.value); err != nil {
+			return err
+		}
+	}
+
+	for _, upload := range data.Uploads {
+		file, err := os.Open(upload.FilePath)
+		if err != nil {
+			manifest.MissingFiles = append(manifest.MissingFiles, upload.Id)
+			continue
+		}
+		err = add(path.Join("uploads", upload.Id, exportFileName(upload)), "Uploaded file "+upload.FileName, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	entry, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// exportFileName returns a safe name for an upload inside the archive
+func exportFileName(upload Upload) string {
+	name := path.Base(strings.ReplaceAll(upload.FileName, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return upload.Id + path.Ext(upload.FilePath)
+	}
+	return name
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// UserProfile holds the extended profile of a user. Subjects and GradeLevels
+// are stored as JSON arrays; use the accessor methods.
+type UserProfile struct {
+	UserID      string    `orm:"pk;column(user_id);size(36)" json:"-"`
+	DisplayName string    `orm:"column(display_name);size(100)" json:"display_name"`
+	Phone       string    `orm:"column(phone);size(32)" json:"phone"`
+	County      string    `orm:"column(county);size(64)" json:"county"`
+	School      string    `orm:"column(school);size(200)" json:"school"`
+	Subjects    string    `orm:"column(subjects);type(text)" json:"-"`
+	GradeLevels string    `orm:"column(grade_levels);type(text)" json:"-"`
+	TSCNumber   string    `orm:"column(tsc_number);size(20)" json:"tsc_number"`
+	UpdatedAt   time.Time `orm:"column(updated_at);type(timestamp with time zone)" json:"updated_at"`
+}
+
+// TableName specifies the database table name
+func (p *UserProfile) TableName() string {
+	return "user_profiles"
+}
+
+// SubjectList returns the subjects the user teaches
+func (p *UserProfile) SubjectList() []string {
+	return decodeStringList(p.Subjects)
+}
+
+// GradeLevelList returns the grade levels the user teaches
+func (p *UserProfile) GradeLevelList() []string {
+	return decodeStringList(p.GradeLevels)
+}
+
+func decodeStringList(s string) []string {
+	list := []string{}
+	if s != "" {
+		json.Unmarshal([]byte(s), &list)
+	}
+	return list
+}
+
+func encodeStringList(list []string) string {
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+// ProfileField declares who may see and edit a profile field
+type ProfileField struct {
+	Name string
+	// Public fields are shown to every signed-in user; the rest only to the
+	// user themselves and to admins
+	Public bool
+	// EditableBy lists the roles that may edit their own value. Empty means
+	// the field is read-only.
+	EditableBy []Role
+}
+
+// ProfileFields lists every field of the profile response with its rules
+var ProfileFields = []ProfileField{
+	{Name: "id", Public: true},
+	{Name: "username", Public: true},
+	{Name: "email"},
+	{Name: "role", Public: true},
+	{Name: "email_verified"},
+	{Name: "created_at", Public: true},
+	{Name: "erasure_scheduled_at"},
+	{Name: "display_name", Public: true, EditableBy: Roles},
+	{Name: "phone", EditableBy: Roles},
+	{Name: "county", Public: true, EditableBy: Roles},
+	{Name: "school", Public: true, EditableBy: Roles},
+	{Name: "subjects", Public: true, EditableBy: []Role{RoleTeacher, RoleAdmin}},
+	{Name: "grade_levels", Public: true, EditableBy: []Role{RoleTeacher, RoleAdmin}},
+	{Name: "tsc_number", EditableBy: []Role{RoleTeacher}},
+}
+
+// profileField returns the rules for the named field
+func profileField(name string) (ProfileField, bool) {
+	for _, field := range ProfileFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return ProfileField{}, false
+}
+
+// CanEdit reports whether a user with the role may edit their own value
+func (f ProfileField) CanEdit(role Role) bool {
+	for _, allowed := range f.EditableBy {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureUserProfilesTable creates the user_profiles table if it doesn't exist
+func EnsureUserProfilesTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS user_profiles (
+		user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		display_name VARCHAR(100) NOT NULL DEFAULT '',
+		phone VARCHAR(32) NOT NULL DEFAULT '',
+		county VARCHAR(64) NOT NULL DEFAULT '',
+		school VARCHAR(200) NOT NULL DEFAULT '',
+		subjects TEXT NOT NULL DEFAULT '[]',
+		grade_levels TEXT NOT NULL DEFAULT '[]',
+		tsc_number VARCHAR(20) NOT NULL DEFAULT '',
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	o := orm.NewOrm()
+	_, err := o.Raw(sql).Exec()
+	return err
+}
+
+// GetUserProfile returns the user's profile, which is empty until the user
+// first saves it
+func GetUserProfile(userID string) (*UserProfile, error) {
+	o := orm.NewOrm()
+	profile := &UserProfile{UserID: userID}
+	if err := o.Read(profile); err != nil {
+		if err == orm.ErrNoRows {
+			return &UserProfile{UserID: userID}, nil
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+// SaveUserProfile creates or replaces the user's profile
+func SaveUserProfile(profile *UserProfile) error {
+	profile.UpdatedAt = time.Now()
+	if profile.Subjects == "" {
+		profile.Subjects = "[]"
+	}
+	if profile.GradeLevels == "" {
+		profile.GradeLevels = "[]"
+	}
+
+	o := orm.NewOrm()
+	_, err := o.Raw(`INSERT INTO user_profiles (user_id, display_name, phone, county, school, subjects, grade_levels, tsc_number, updated_at)
+					 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+					 ON CONFLICT (user_id) DO UPDATE SET display_name = EXCLUDED.display_name, phone = EXCLUDED.phone,
+					 county = EXCLUDED.county, school = EXCLUDED.school, subjects = EXCLUDED.subjects,
+					 grade_levels = EXCLUDED.grade_levels, tsc_number = EXCLUDED.tsc_number, updated_at = EXCLUDED.updated_at`,
+		profile.UserID, profile.DisplayName, profile.Phone, profile.County, profile.School,
+		profile.Subjects, profile.GradeLevels, profile.TSCNumber, profile.UpdatedAt).Exec()
+	return err
+}
+
+// ProfileView returns the user's profile as seen by the viewer. Private
+// fields are left out unless the viewer is the user or an admin.
+func ProfileView(user *User, profile *UserProfile, viewerID string, viewerRole Role) map[string]interface{} {
+	values := map[string]interface{}{
+		"id":                   user.ID,
+		"username":             user.Username,
+		"email":                user.Email,
+		"role":                 user.Role,
+		"email_verified":       user.VerifiedAt != nil,
+		"created_at":           user.CreatedAt,
+		"erasure_scheduled_at": user.ErasureScheduledAt,
+		"display_name":         profile.DisplayName,
+		"phone":                profile.Phone,
+		"county":               profile.County,
+		"school":               profile.School,
+		"subjects":             profile.SubjectList(),
+		"grade_levels":         profile.GradeLevelList(),
+		"tsc_number":           profile.TSCNumber,
+	}
+
+	full := viewerID == user.ID || viewerRole == RoleAdmin
+	view := make(map[string]interface{}, len(values))
+	for _, field := range ProfileFields {
+		if full || field.Public {
+			view[field.Name] = values[field.Name]
+		}
+	}
+	return view
+}
+
+// ProfileUpdate is a partial profile update; nil fields are left unchanged
+type ProfileUpdate struct {
+	DisplayName *string   `json:"display_name"`
+	Phone       *string   `json:"phone"`
+	County      *string   `json:"county"`
+	School      *string   `json:"school"`
+	Subjects    *[]string `json:"subjects"`
+	GradeLevels *[]string `json:"grade_levels"`
+	TSCNumber   *string   `json:"tsc_number"`
+}
+
+// ProfileFieldError lists the fields of an update that were rejected
+type ProfileFieldError struct {
+	Forbidden []string          `json:"forbidden,omitempty"` // Not editable by the user's role
+	Invalid   map[string]string `json:"invalid,omitempty"`   // Field name to problem
+}
+
+func (e *ProfileFieldError) Error() string {
+	var problems []string
+	if len(e.Forbidden) > 0 {
+		problems = append(problems, "not editable: "+strings.Join(e.Forbidden, ", "))
+	}
+	for field, problem := range e.Invalid {
+		problems = append(problems, field+": "+problem)
+	}
+	return "invalid profile update (" + strings.Join(problems, "; ") + ")"
+}
+
+var (
+	phonePattern     = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{6,19}$`)
+	tscNumberPattern = regexp.MustCompile(`^[0-9]{4,10}$`)
+)
+
+const (
+	maxListItems   = 20
+	maxListItemLen = 50
+)
+
+// Apply validates the update against the field rules for the role and
+// applies it to the profile. Nothing is changed if any field is rejected.
+func (u *ProfileUpdate) Apply(profile *UserProfile, role Role) error {
+	fieldErr := &ProfileFieldError{Invalid: map[string]string{}}
+	updated := *profile
+
+	setText := func(name string, value *string, target *string, maxLen int, pattern *regexp.Regexp) {
+		if value == nil {
+			return
+		}
+		if field, _ := profileField(name); !field.CanEdit(role) {
+			fieldErr.Forbidden = append(fieldErr.Forbidden, name)
+			return
+		}
+		v := strings.TrimSpace(*value)
+		switch {
+		case len(v) > maxLen:
+			fieldErr.Invalid[name] = fmt.Sprintf("must be at most %d characters", maxLen)
+		case v != "" && pattern != nil && !pattern.MatchString(v):
+			fieldErr.Invalid[name] = "has an invalid format"
+		default:
+			*target = v
+		}
+	}
+	setList := func(name string, value *[]string, target *string) {
+		if value == nil {
+			return
+		}
+		if field, _ := profileField(name); !field.CanEdit(role) {
+			fieldErr.Forbidden = append(fieldErr.Forbidden, name)
+			return
+		}
+		if len(*value) > maxListItems {
+			fieldErr.Invalid[name] = fmt.Sprintf("must have at most %d items", maxListItems)
+			return
+		}
+		list := []string{}
+		seen := map[string]bool{}
+		for _, item := range *value {
+			item = strings.TrimSpace(item)
+			if item == "" || seen[strings.ToLower(item)] {
+				continue
+			}
+			if len(item) > maxListItemLen {
+				fieldErr.Invalid[name] = fmt.Sprintf("items must be at most %d characters", maxListItemLen)
+				return
+			}
+			seen[strings.ToLower(item)] = true
+			list = append(list, item)
+		}
+		*target = encodeStringList(list)
+	}
+
+	setText("display_name", u.DisplayName, &updated.DisplayName, 100, nil)
+	setText("phone", u.Phone, &updated.Phone, 32, phonePattern)
+	setText("county", u.County, &updated.County, 64, nil)
+	setText("school", u.School, &updated.School, 200, nil)
+	setList("subjects", u.Subjects, &updated.Subjects)
+	setList("grade_levels", u.GradeLevels, &updated.GradeLevels)
+	setText("tsc_number", u.TSCNumber, &updated.TSCNumber, 20, tscNumberPattern)
+
+	if len(fieldErr.Forbidden) > 0 || len(fieldErr.Invalid) > 0 {
+		return fieldErr
+	}
+	*profile = updated
+	return nil
+}
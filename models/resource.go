@@ -3,6 +3,8 @@ package models
 import (
 	"strings"
 	"time"
+
+	"github.com/beego/beego/v2/client/orm"
 )
 
 // Resource represents an existing resource in the system
@@ -23,17 +25,154 @@ func (r *Resource) TableName() string {
 	return "web_crawler_resources"
 }
 
-// GetCategories returns the categories as a string slice
+// GetCategories returns the categories as a string slice. Categories holds a
+// Postgres array literal such as {Grade 4,"Maths, Term 1"}; an empty or
+// malformed value has no categories.
 func (r *Resource) GetCategories() []string {
-	// Remove the curly braces and split by comma
-	categoriesStr := r.Categories[1 : len(r.Categories)-1] // Remove { and }
-	if categoriesStr == "" {
-		return []string{}
+	categories := []string{}
+	value := strings.TrimSpace(r.Categories)
+	if len(value) < 2 || value[0] != '{' || value[len(value)-1] != '}' {
+		return categories
+	}
+
+	var element strings.Builder
+	quoted, escaped, wasQuoted := false, false, false
+	flush := func() {
+		item := element.String()
+		if !wasQuoted {
+			item = strings.TrimSpace(item)
+		}
+		if wasQuoted || (item != "" && !strings.EqualFold(item, "NULL")) {
+			categories = append(categories, item)
+		}
+		element.Reset()
+		wasQuoted = false
+	}
+	for _, ch := range value[1 : len(value)-1] {
+		switch {
+		case escaped:
+			element.WriteRune(ch)
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '"':
+			quoted = !quoted
+			wasQuoted = true
+		case ch == ',' && !quoted:
+			flush()
+		default:
+			element.WriteRune(ch)
+		}
 	}
-	return strings.Split(categoriesStr, ",")
+	flush()
+	return categories
 }
 
 // SetCategories sets the categories from a string slice
 func (r *Resource) SetCategories(categories []string) {
 	r.Categories = "{" + strings.Join(categories, ",") + "}"
 }
+
+// GetResource retrieves a resource by ID
+func GetResource(id string) (*Resource, error) {
+	o := orm.NewOrm()
+	resource := &Resource{}
+	err := o.QueryTable("web_crawler_resources").Filter("id", id).One(resource)
+	return resource, err
+}
+
+// Breadcrumb is a folder on the path to a resource. Path is the folder's
+// path relative to the resource root.
+type Breadcrumb struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Breadcrumbs returns the folders containing the resource, outermost first,
+// derived from its RelativePath
+func (r *Resource) Breadcrumbs() []Breadcrumb {
+	var segments []string
+	for _, segment := range strings.Split(strings.ReplaceAll(r.RelativePath, "\\", "/"), "/") {
+		if segment != "" && segment != "." {
+			segments = append(segments, segment)
+		}
+	}
+	// The path usually ends with the resource itself
+	if len(segments) > 0 && segments[len(segments)-1] == r.Name {
+		segments = segments[:len(segments)-1]
+	}
+
+	breadcrumbs := make([]Breadcrumb, len(segments))
+	for i, segment := range segments {
+		breadcrumbs[i] = Breadcrumb{Name: segment, Path: strings.Join(segments[:i+1], "/")}
+	}
+	return breadcrumbs
+}
+
+// ResourceDetail is a resource with its categories parsed and related
+// metadata, as returned by the resource detail endpoint. Crawled resources
+// record no uploader; Source is the page the crawler found them on.
+type ResourceDetail struct {
+	*Resource
+	Source        string           `json:"source"`
+	Categories    []string         `json:"categories"`
+	DownloadCount int64            `json:"download_count"`
+	Breadcrumbs   []Breadcrumb     `json:"breadcrumbs"`
+	Metadata      ResourceMetadata `json:"metadata"`
+}
+
+// GetResourceDetail retrieves a resource with its related metadata
+func GetResourceDetail(id string) (*ResourceDetail, error) {
+	resource, err := GetResource(id)
+	if err != nil {
+		return nil, err
+	}
+
+	o := orm.NewOrm()
+	var downloads int64
+	err = o.Raw(`SELECT COALESCE(SUM(download_count), 0) FROM resource_downloads WHERE resource_id = ?`, id).
+		QueryRow(&downloads)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := getResourceMetadata(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResourceDetail{
+		Resource:      resource,
+		Source:        resource.ParentUrl,
+		Categories:    resource.GetCategories(),
+		DownloadCount: downloads,
+		Breadcrumbs:   resource.Breadcrumbs(),
+		Metadata:      metadata,
+	}, nil
+}
+
+// EnsureResourceDownloadsTable creates the resource_downloads table if it
+// doesn't exist. web_crawler_resources belongs to the crawler, which may
+// replace its rows, so counts are kept here, without a foreign key.
+func EnsureResourceDownloadsTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS resource_downloads (
+		resource_id UUID PRIMARY KEY,
+		download_count BIGINT NOT NULL DEFAULT 0,
+		last_downloaded_at TIMESTAMP WITH TIME ZONE
+	)`
+
+	o := orm.NewOrm()
+	_, err := o.Raw(sql).Exec()
+	return err
+}
+
+// RecordResourceDownload counts a download of the resource
+func RecordResourceDownload(id string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`INSERT INTO resource_downloads (resource_id, download_count, last_downloaded_at)
+					 VALUES (?, 1, CURRENT_TIMESTAMP)
+					 ON CONFLICT (resource_id) DO UPDATE
+					 SET download_count = resource_downloads.download_count + 1,
+					     last_downloaded_at = CURRENT_TIMESTAMP`, id).Exec()
+	return err
+}
@@ -0,0 +1,350 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ResourceMetadataVersion is the version of the metadata extractor. Bump it
+// when the extraction rules change, so that the next run extracts every
+// resource again.
+const ResourceMetadataVersion = 1
+
+// resourceMetadataBatch is how many resources are extracted per transaction
+const resourceMetadataBatch = 500
+
+// ResourceMetadata is the CBC metadata extracted from a resource's name,
+// folders and categories. Fields are nil when nothing was found.
+type ResourceMetadata struct {
+	ResourceID       string    `orm:"pk;column(resource_id);type(uuid)" json:"-"`
+	Grade            *string   `orm:"column(grade);null;size(16)" json:"grade"` // PP1, PP2, Grade 1-12 or Form 1-4
+	Subject          *string   `orm:"column(subject);null;size(64)" json:"subject"`
+	Term             *int      `orm:"column(term);null" json:"term"`
+	Year             *int      `orm:"column(year);null" json:"year"`
+	ExamType         *string   `orm:"column(exam_type);null;size(32)" json:"exam_type"`
+	MarkingScheme    bool      `orm:"column(marking_scheme)" json:"marking_scheme"`
+	ExtractorVersion int       `orm:"column(extractor_version)" json:"-"`
+	ExtractedAt      time.Time `orm:"column(extracted_at);type(timestamp with time zone)" json:"-"`
+}
+
+// EnsureResourceMetadataTable creates the resource_metadata table if it
+// doesn't exist. Like resource_downloads it has no foreign key to the
+// crawler's table; extraction removes rows of resources that are gone.
+func EnsureResourceMetadataTable() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS resource_metadata (
+			resource_id UUID PRIMARY KEY,
+			grade VARCHAR(16),
+			subject VARCHAR(64),
+			term SMALLINT,
+			year SMALLINT,
+			exam_type VARCHAR(32),
+			marking_scheme BOOLEAN NOT NULL DEFAULT FALSE,
+			extractor_version INTEGER NOT NULL,
+			extracted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS resource_metadata_grade_subject_idx ON resource_metadata (grade, subject)`,
+		`CREATE INDEX IF NOT EXISTS resource_metadata_year_term_idx ON resource_metadata (year, term)`,
+		`CREATE INDEX IF NOT EXISTS resource_metadata_exam_type_idx ON resource_metadata (exam_type)`,
+	}
+
+	o := orm.NewOrm()
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numberWords are the spelled out numbers found in grades and terms
+var numberWords = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+	"ten": 10, "eleven": 11, "twelve": 12, "i": 1, "ii": 2, "iii": 3,
+}
+
+var (
+	metadataSeparators = strings.NewReplacer("_", " ", "-", " ", ".", " ", "/", " ", "\\", " ",
+		"(", " ", ")", " ", "[", " ", "]", " ", ",", " ", "&", " and ", "+", " ")
+	ppPattern            = regexp.MustCompile(`\b(?:pp|pre ?primary)\s*([12]|one|two)\b`)
+	gradePattern         = regexp.MustCompile(`\b(?:grade|grd|gr|g)\s*(1[0-2]|[1-9]|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve)\b`)
+	formPattern          = regexp.MustCompile(`\bform\s*([1-4]|one|two|three|four)\b`)
+	termPattern          = regexp.MustCompile(`\bterm\s*([1-3]|one|two|three|iii|ii|i)\b`)
+	yearPattern          = regexp.MustCompile(`\b(19[89][0-9]|20[0-9]{2})\b`)
+	markingSchemePattern = regexp.MustCompile(`\bmarking ?schemes?\b`)
+)
+
+// synonyms is a canonical name and the words naming it, in lowercase
+type synonyms struct {
+	name  string
+	words []string
+}
+
+// examTypes are the exam types and the words naming them
+var examTypes = []synonyms{
+	{"KPSEA", []string{"kpsea"}},
+	{"KJSEA", []string{"kjsea"}},
+	{"KCPE", []string{"kcpe"}},
+	{"KCSE", []string{"kcse"}},
+	{"Mock", []string{"mock", "mocks"}},
+	{"Opener", []string{"opener", "opening exam", "opening exams"}},
+	{"Mid Term", []string{"mid term", "midterm", "mid year"}},
+	{"End Term", []string{"end term", "endterm", "end of term", "end year", "end of year"}},
+	{"CAT", []string{"cat", "cats"}},
+	{"Revision", []string{"revision", "revision questions"}},
+}
+
+// subjects maps each subject to the words naming it, including the names of
+// CBC learning areas, 8-4-4 subjects and common abbreviations
+var subjects = []synonyms{
+	{"Mathematics", []string{"mathematics", "maths", "math", "mathematical activities", "hisabati"}},
+	{"English", []string{"english", "eng", "english activities", "english language", "literacy"}},
+	{"Kiswahili", []string{"kiswahili", "kisw", "swahili", "shughuli za kiswahili", "lugha"}},
+	{"Integrated Science", []string{"integrated science", "int science", "int sci"}},
+	{"Science and Technology", []string{"science and technology", "sci tech", "science tech", "science"}},
+	{"Social Studies", []string{"social studies", "sst", "social"}},
+	{"CRE", []string{"cre", "christian religious education", "christian religious"}},
+	{"IRE", []string{"ire", "islamic religious education", "islamic religious"}},
+	{"HRE", []string{"hre", "hindu religious education"}},
+	{"Religious Education", []string{"religious education", "religious activities"}},
+	{"Agriculture", []string{"agriculture", "agric", "agriculture and nutrition"}},
+	{"Home Science", []string{"home science", "homescience"}},
+	{"Creative Arts", []string{"creative arts", "creative arts and sports", "creative activities", "art and craft"}},
+	{"Pre-Technical Studies", []string{"pre technical studies", "pre technical", "pretechnical", "pre tech"}},
+	{"Environmental Activities", []string{"environmental activities", "environmental"}},
+	{"Hygiene and Nutrition", []string{"hygiene and nutrition", "hygiene"}},
+	{"Movement Activities", []string{"movement activities", "movement"}},
+	{"Physical Education", []string{"physical education", "pe", "physical and health education"}},
+	{"Health Education", []string{"health education"}},
+	{"Life Skills", []string{"life skills", "life skills education"}},
+	{"Computer Studies", []string{"computer studies", "computer science", "computer", "ict"}},
+	{"Business Studies", []string{"business studies", "business"}},
+	{"Biology", []string{"biology", "bio"}},
+	{"Chemistry", []string{"chemistry", "chem"}},
+	{"Physics", []string{"physics", "phy"}},
+	{"Geography", []string{"geography", "geo"}},
+	{"History", []string{"history", "history and government", "history and citizenship", "hist"}},
+	{"French", []string{"french"}},
+	{"German", []string{"german"}},
+	{"Arabic", []string{"arabic"}},
+	{"Music", []string{"music"}},
+	{"Art and Design", []string{"art and design"}},
+}
+
+// normalizeMetadataText lowercases text and turns punctuation into spaces,
+// padding the result with spaces so that words can be matched as " word "
+func normalizeMetadataText(text string) string {
+	text = metadataSeparators.Replace(strings.ToLower(text))
+	return " " + strings.Join(strings.Fields(text), " ") + " "
+}
+
+// parseNumber reads a digit or a spelled out number
+func parseNumber(s string) int {
+	if n, ok := numberWords[s]; ok {
+		return n
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// findSynonym returns the name whose word appears first in the normalized
+// text, preferring the longest word at the same position, so that "KCSE
+// mock" is a KCSE paper and "Mock KCSE" a mock
+func findSynonym(text string, names []synonyms) string {
+	best, bestAt, bestLen := "", len(text), 0
+	for _, entry := range names {
+		for _, word := range entry.words {
+			at := strings.Index(text, " "+word+" ")
+			if at >= 0 && (at < bestAt || (at == bestAt && len(word) > bestLen)) {
+				best, bestAt, bestLen = entry.name, at, len(word)
+			}
+		}
+	}
+	return best
+}
+
+// extractGrade finds a PP, grade or form level in normalized text
+func extractGrade(text string) string {
+	if m := ppPattern.FindStringSubmatch(text); m != nil {
+		return fmt.Sprintf("PP%d", parseNumber(m[1]))
+	}
+	if m := gradePattern.FindStringSubmatch(text); m != nil {
+		return fmt.Sprintf("Grade %d", parseNumber(m[1]))
+	}
+	if m := formPattern.FindStringSubmatch(text); m != nil {
+		return fmt.Sprintf("Form %d", parseNumber(m[1]))
+	}
+	return ""
+}
+
+// extractYear finds a plausible year in normalized text
+func extractYear(text string) int {
+	maxYear := time.Now().Year() + 1
+	for _, m := range yearPattern.FindAllString(text, -1) {
+		if year, _ := strconv.Atoi(m); year <= maxYear {
+			return year
+		}
+	}
+	return 0
+}
+
+// ExtractResourceMetadata extracts the CBC metadata of a resource. The name
+// is searched first, then the folder, the rest of the path and the
+// categories, and each field takes the first value found.
+func ExtractResourceMetadata(r *Resource) ResourceMetadata {
+	metadata := ResourceMetadata{ResourceID: r.Id, ExtractorVersion: ResourceMetadataVersion}
+	sources := []string{r.Name, r.ParentDirectory, r.RelativePath, strings.Join(r.GetCategories(), " / ")}
+	for _, source := range sources {
+		text := normalizeMetadataText(source)
+		if metadata.Grade == nil {
+			if grade := extractGrade(text); grade != "" {
+				metadata.Grade = &grade
+			}
+		}
+		if metadata.Subject == nil {
+			if subject := findSynonym(text, subjects); subject != "" {
+				metadata.Subject = &subject
+			}
+		}
+		if metadata.Term == nil {
+			if m := termPattern.FindStringSubmatch(text); m != nil {
+				term := parseNumber(m[1])
+				metadata.Term = &term
+			}
+		}
+		if metadata.Year == nil {
+			if year := extractYear(text); year != 0 {
+				metadata.Year = &year
+			}
+		}
+		if metadata.ExamType == nil {
+			if examType := findSynonym(text, examTypes); examType != "" {
+				metadata.ExamType = &examType
+			}
+		}
+		if markingSchemePattern.MatchString(text) {
+			metadata.MarkingScheme = true
+		}
+	}
+	return metadata
+}
+
+// NormalizeGrade converts a grade as users write it, e.g. "grade 7", "G7"
+// or "pp 2", to the stored form. It returns "" if it isn't a grade.
+func NormalizeGrade(value string) string {
+	return extractGrade(normalizeMetadataText(value))
+}
+
+// NormalizeSubject converts a subject name or synonym to the stored name.
+// It returns "" if it isn't a known subject.
+func NormalizeSubject(value string) string {
+	return findSynonym(normalizeMetadataText(value), subjects)
+}
+
+// NormalizeExamType converts an exam type or synonym to the stored name. It
+// returns "" if it isn't a known exam type.
+func NormalizeExamType(value string) string {
+	return findSynonym(normalizeMetadataText(value), examTypes)
+}
+
+// ExtractPendingResourceMetadata extracts the metadata of the resources that
+// have none yet or were extracted by an older extractor, or of every
+// resource with all set, and removes the metadata of resources that are
+// gone. It returns how many resources were extracted.
+func ExtractPendingResourceMetadata(all bool) (int, error) {
+	o := orm.NewOrm()
+	if _, err := o.Raw(`DELETE FROM resource_metadata m
+						WHERE NOT EXISTS (SELECT 1 FROM web_crawler_resources r WHERE r.id = m.resource_id)`).Exec(); err != nil {
+		return 0, err
+	}
+
+	// Extracted rows no longer match, so each batch picks up new ones. The
+	// start is read from the database, whose clock sets extracted_at.
+	var started time.Time
+	if err := o.Raw(`SELECT CURRENT_TIMESTAMP`).QueryRow(&started); err != nil {
+		return 0, err
+	}
+	pending := `m.resource_id IS NULL OR m.extractor_version < ?`
+	args := []interface{}{ResourceMetadataVersion}
+	if all {
+		pending += ` OR m.extracted_at < ?`
+		args = append(args, started)
+	}
+
+	extracted := 0
+	for {
+		var resources []Resource
+		_, err := o.Raw(`SELECT r.* FROM web_crawler_resources r
+						 LEFT JOIN resource_metadata m ON m.resource_id = r.id
+						 WHERE `+pending+` ORDER BY r.id LIMIT ?`, append(args, resourceMetadataBatch)...).
+			QueryRows(&resources)
+		if err != nil {
+			return extracted, err
+		}
+		if len(resources) == 0 {
+			return extracted, nil
+		}
+
+		err = o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+			for i := range resources {
+				if err := saveResourceMetadata(txOrm, ExtractResourceMetadata(&resources[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return extracted, err
+		}
+		extracted += len(resources)
+	}
+}
+
+// saveResourceMetadata inserts or replaces the metadata of a resource
+func saveResourceMetadata(o orm.QueryExecutor, m ResourceMetadata) error {
+	_, err := o.Raw(`INSERT INTO resource_metadata
+					 (resource_id, grade, subject, term, year, exam_type, marking_scheme, extractor_version, extracted_at)
+					 VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+					 ON CONFLICT (resource_id) DO UPDATE SET
+					 grade = EXCLUDED.grade, subject = EXCLUDED.subject, term = EXCLUDED.term, year = EXCLUDED.year,
+					 exam_type = EXCLUDED.exam_type, marking_scheme = EXCLUDED.marking_scheme,
+					 extractor_version = EXCLUDED.extractor_version, extracted_at = EXCLUDED.extracted_at`,
+		m.ResourceID, nullString(m.Grade), nullString(m.Subject), nullInt(m.Term), nullInt(m.Year),
+		nullString(m.ExamType), m.MarkingScheme, m.ExtractorVersion).Exec()
+	return err
+}
+
+// nullString passes an optional string as a query argument. The ORM can't
+// bind nil pointers.
+func nullString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+// nullInt passes an optional integer as a query argument
+func nullInt(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// getResourceMetadata returns the stored metadata of a resource, or extracts
+// it if the resource hasn't been extracted yet
+func getResourceMetadata(r *Resource) (ResourceMetadata, error) {
+	o := orm.NewOrm()
+	var metadata ResourceMetadata
+	err := o.Raw(`SELECT * FROM resource_metadata WHERE resource_id = ?`, r.Id).QueryRow(&metadata)
+	if err == orm.ErrNoRows {
+		return ExtractResourceMetadata(r), nil
+	}
+	return metadata, err
+}
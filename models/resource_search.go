@@ -0,0 +1,231 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// searchConfig is the text search configuration resources are indexed with.
+// It stems words, so that "maths" also finds "math".
+const searchConfig = "english"
+
+// searchable turns path separators and underscores in a column into spaces,
+// so that "Grade_7/Maths" is indexed as separate words
+func searchable(column string) string {
+	return fmt.Sprintf(`translate(coalesce(%s, ''), '/\_-.', '     ')`, column)
+}
+
+// resourceDocument is the text search document of a resource. Matches in
+// the name rank above matches in the folder, which rank above the rest of
+// the path. The GIN index is on this exact expression, so queries must use
+// it unchanged.
+var resourceDocument = fmt.Sprintf(
+	`(setweight(to_tsvector('%[1]s', %[2]s), 'A') || `+
+		`setweight(to_tsvector('%[1]s', %[3]s), 'B') || `+
+		`setweight(to_tsvector('%[1]s', %[4]s), 'C'))`,
+	searchConfig, searchable("name"), searchable("parent_directory"), searchable("relative_path"))
+
+// resourceHeadline highlights the query's words in a resource's name and
+// path with <mark> tags. The text is HTML escaped first, so that the
+// snippet can be rendered as HTML.
+var resourceHeadline = fmt.Sprintf(
+	`ts_headline('%s', replace(replace(replace(coalesce(name, '') || ' · ' || coalesce(relative_path, ''), `+
+		`'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), websearch_to_tsquery('%[1]s', ?), `+
+		`'StartSel=<mark>, StopSel=</mark>, MinWords=10, MaxWords=30, MaxFragments=2')`,
+	searchConfig)
+
+// resourceTables are the resources along with their extracted metadata,
+// which resources not extracted yet have none of
+const resourceTables = `web_crawler_resources LEFT JOIN resource_metadata
+						ON resource_metadata.resource_id = web_crawler_resources.id`
+
+// resourceTopDirectory is the top-level folder of a resource, which the
+// directory facet counts and filters by
+const resourceTopDirectory = `split_part(trim(both '/' from coalesce(parent_directory, '')), '/', 1)`
+
+// facetLimit is the most buckets returned per facet
+const facetLimit = 100
+
+// EnsureResourceSearchIndex creates the full-text index over resources. The
+// crawler owns web_crawler_resources, so the index is on an expression
+// rather than a column of our own.
+func EnsureResourceSearchIndex() error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`CREATE INDEX IF NOT EXISTS web_crawler_resources_search_idx
+					 ON web_crawler_resources USING GIN (` + resourceDocument + `)`).Exec()
+	return err
+}
+
+// ResourceSearch holds the filters of a resource search
+type ResourceSearch struct {
+	// Query is matched against the name, folder and path of resources in
+	// web search syntax: words in any order, "quoted phrases", "or" and -word
+	Query string
+	// Categories selects resources having every one of the categories, or
+	// any of them with AnyCategory
+	Categories  []string
+	AnyCategory bool
+	Directory   string // Top-level folder
+
+	// Metadata filters, matched against the metadata extracted from the
+	// names and paths of resources. Zero values don't filter.
+	Grade         string // As stored, see NormalizeGrade
+	Subject       string // As stored, see NormalizeSubject
+	Term          int
+	Year          int
+	ExamType      string // As stored, see NormalizeExamType
+	MarkingScheme *bool
+}
+
+// Resource search facets, which a facet's counts leave their own filter out
+// of
+const (
+	facetNone        = ""
+	facetCategories  = "categories"
+	facetDirectories = "directories"
+)
+
+// FacetBucket is a facet value and the number of matching resources with it
+type FacetBucket struct {
+	Value string `orm:"column(value)" json:"value"`
+	Count int64  `orm:"column(count)" json:"count"`
+}
+
+// ResourceFacets counts the resources matching a search by category and by
+// top-level folder, most common first
+type ResourceFacets struct {
+	Categories  []FacetBucket `json:"categories"`
+	Directories []FacetBucket `json:"directories"`
+}
+
+// ResourceSearchResult is a resource matching a search. Rank and Highlight
+// are only set when the search has a query.
+type ResourceSearchResult struct {
+	Resource
+	Metadata  ResourceMetadata `json:"metadata"`
+	Rank      float64          `orm:"column(rank)" json:"rank,omitempty"`
+	Highlight string           `orm:"column(highlight)" json:"highlight,omitempty"`
+}
+
+// where returns the SQL condition selecting the resources matching the
+// search, along with its arguments. The filter of the except facet is left
+// out, so that its counts show what selecting other values would give.
+func (s ResourceSearch) where(except string) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if s.Query != "" {
+		conditions = append(conditions, resourceDocument+` @@ websearch_to_tsquery('`+searchConfig+`', ?)`)
+		args = append(args, s.Query)
+	}
+	if len(s.Categories) > 0 && except != facetCategories {
+		operator := "@>" // Contains all
+		if s.AnyCategory {
+			operator = "&&" // Overlaps
+		}
+		conditions = append(conditions, `categories::text[] `+operator+` ?::text[]`)
+		args = append(args, textArray(s.Categories))
+	}
+	if s.Directory != "" && except != facetDirectories {
+		conditions = append(conditions, resourceTopDirectory+` = ?`)
+		args = append(args, s.Directory)
+	}
+
+	metadata := []struct {
+		column string
+		value  interface{}
+		set    bool
+	}{
+		{"grade", s.Grade, s.Grade != ""},
+		{"subject", s.Subject, s.Subject != ""},
+		{"term", s.Term, s.Term != 0},
+		{"year", s.Year, s.Year != 0},
+		{"exam_type", s.ExamType, s.ExamType != ""},
+		{"marking_scheme", s.MarkingScheme, s.MarkingScheme != nil},
+	}
+	for _, filter := range metadata {
+		if filter.set {
+			conditions = append(conditions, filter.column+` = ?`)
+			args = append(args, filter.value)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// SearchResources returns a page of the resources matching the search and
+// the number of matches. With a query, the best matches come first and each
+// has a highlighted snippet; otherwise the newest resources come first.
+func SearchResources(s ResourceSearch, page, pageSize int) ([]ResourceSearchResult, int64, error) {
+	o := orm.NewOrm()
+	where, args := s.where(facetNone)
+
+	var total int64
+	if err := o.Raw(`SELECT COUNT(*) FROM `+resourceTables+` WHERE `+where, args...).QueryRow(&total); err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	var sql string
+	var queryArgs []interface{}
+	if s.Query == "" {
+		sql = `SELECT * FROM ` + resourceTables + ` WHERE ` + where + `
+			   ORDER BY created_at DESC LIMIT ? OFFSET ?`
+		queryArgs = append(args, pageSize, offset)
+	} else {
+		// Snippets are costly, so they are only made for the page
+		sql = `SELECT matches.*, ` + resourceHeadline + ` AS highlight
+			   FROM (SELECT *, ts_rank(` + resourceDocument + `, websearch_to_tsquery('` + searchConfig + `', ?)) AS rank
+			         FROM ` + resourceTables + ` WHERE ` + where + `
+			         ORDER BY rank DESC, created_at DESC LIMIT ? OFFSET ?) matches
+			   ORDER BY rank DESC, created_at DESC`
+		queryArgs = append([]interface{}{s.Query, s.Query}, args...)
+		queryArgs = append(queryArgs, pageSize, offset)
+	}
+
+	results := []ResourceSearchResult{}
+	if _, err := o.Raw(sql, queryArgs...).QueryRows(&results); err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// SearchResourceFacets counts the resources matching the search by category
+// and by top-level folder. Each facet's counts respect every filter but its
+// own, so that several of its values can be selected.
+func SearchResourceFacets(s ResourceSearch) (*ResourceFacets, error) {
+	o := orm.NewOrm()
+	facets := &ResourceFacets{Categories: []FacetBucket{}, Directories: []FacetBucket{}}
+
+	where, args := s.where(facetCategories)
+	_, err := o.Raw(`SELECT category AS value, COUNT(*) AS count
+					 FROM `+resourceTables+`, unnest(categories::text[]) AS category
+					 WHERE `+where+` AND category <> ''
+					 GROUP BY category ORDER BY count DESC, category LIMIT ?`, append(args, facetLimit)...).
+		QueryRows(&facets.Categories)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args = s.where(facetDirectories)
+	_, err = o.Raw(`SELECT `+resourceTopDirectory+` AS value, COUNT(*) AS count
+					 FROM `+resourceTables+`
+					 WHERE `+where+` AND `+resourceTopDirectory+` <> ''
+					 GROUP BY value ORDER BY count DESC, value LIMIT ?`, append(args, facetLimit)...).
+		QueryRows(&facets.Directories)
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// textArray formats values as a Postgres array literal, quoting every
+// element so that commas, braces and quotes in them are kept
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		escaped := strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`)
+		quoted[i] = `"` + escaped + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
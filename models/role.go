@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role is the account type of a user. Only the values below are accepted;
+// the users table enforces the same set with a CHECK constraint.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleTeacher  Role = "teacher"
+	RoleStudent  Role = "student"
+	RoleEmployer Role = "employer"
+)
+
+// DefaultRole is assigned to self-registered accounts
+const DefaultRole = RoleTeacher
+
+// Roles lists every valid role
+var Roles = []Role{RoleAdmin, RoleTeacher, RoleStudent, RoleEmployer}
+
+// Permission names an action that routes can require instead of a fixed role
+type Permission string
+
+const (
+	PermResourcesWrite Permission = "resources:write"
+	PermJobsRead       Permission = "jobs:read"
+	PermJobsWrite      Permission = "jobs:write"
+	PermUsersManage    Permission = "users:manage"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:    {PermResourcesWrite, PermJobsRead, PermJobsWrite, PermUsersManage},
+	RoleTeacher:  {PermResourcesWrite, PermJobsRead, PermJobsWrite},
+	RoleStudent:  {PermJobsRead},
+	RoleEmployer: {PermJobsRead, PermJobsWrite},
+}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions returns the permissions the role grants
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Can reports whether the role grants the permission
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRole converts a string to a Role, rejecting unknown values
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if !role.Valid() {
+		return "", fmt.Errorf("invalid role %q", s)
+	}
+	return role, nil
+}
+
+// roleCheckSQL returns the SQL list of valid roles for CHECK constraints
+func roleCheckSQL() string {
+	quoted := make([]string, len(Roles))
+	for i, role := range Roles {
+		quoted[i] = "'" + string(role) + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -1,76 +1,137 @@
 package models
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"cbc-backend/config"
+	"crypto/subtle"
 	"time"
 
 	"github.com/beego/beego/v2/client/orm"
 )
 
+// Session is a server-side login session used by cookie-authenticated
+// clients. Only the hash of the session cookie is stored in Id.
 type Session struct {
-	Id        string    `orm:"pk;size(36)" json:"id"`
-	UserId    int       `orm:"column(user_id)" json:"user_id"`
-	CreatedAt time.Time `orm:"auto_now_add;type(timestamp)" json:"created_at"`
-	ExpiresAt time.Time `orm:"type(timestamp)" json:"expires_at"`
+	Id        string    `orm:"pk;size(64)" json:"-"`
+	UserId    string    `orm:"column(user_id);size(36)" json:"user_id"`
+	CsrfToken string    `orm:"column(csrf_token);size(64)" json:"-"`
+	DeviceId  *string   `orm:"column(device_id);null;size(36)" json:"device_id"`
+	Mfa       bool      `orm:"column(mfa);default(false)" json:"mfa"`
+	CreatedAt time.Time `orm:"auto_now_add;type(timestamp with time zone)" json:"created_at"`
+	ExpiresAt time.Time `orm:"type(timestamp with time zone)" json:"expires_at"`
 }
 
 func (s *Session) TableName() string {
 	return "session"
 }
 
-// CreateSession creates a new session and returns the session ID
-func CreateSession() string {
-	// Generate random session ID
-	bytes := make([]byte, 32)
-	rand.Read(bytes)
-	sessionID := hex.EncodeToString(bytes)
+// EnsureSessionsTable creates the session table if it doesn't exist
+func EnsureSessionsTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS session (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		csrf_token VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+	)`
+
+	o := orm.NewOrm()
+	if _, err := o.Raw(sql).Exec(); err != nil {
+		return err
+	}
+
+	// Sessions end when their device is signed out
+	migrations := []string{
+		`ALTER TABLE session ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE session ADD COLUMN IF NOT EXISTS device_id VARCHAR(36) REFERENCES user_devices(id) ON DELETE CASCADE`,
+	}
+	for _, migration := range migrations {
+		if _, err := o.Raw(migration).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateSession creates a new session for the user and returns the session
+// cookie value along with the stored session. deviceID is the device the user
+// signed in on, and mfa records whether the login completed two-factor
+// authentication.
+func CreateSession(userID, deviceID string, mfa bool) (string, *Session, error) {
+	sessionID, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
 
-	// Create session with 24 hour expiry
 	session := &Session{
-		Id:        sessionID,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		Id:        hashToken(sessionID),
+		UserId:    userID,
+		CsrfToken: csrfToken,
+		DeviceId:  &deviceID,
+		Mfa:       mfa,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(config.SessionTTL),
 	}
 
 	o := orm.NewOrm()
-	_, err := o.Insert(session)
+	_, err = o.Raw(`INSERT INTO session (id, user_id, csrf_token, device_id, mfa, created_at, expires_at)
+					 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.Id, session.UserId, session.CsrfToken, deviceID, session.Mfa, session.CreatedAt, session.ExpiresAt).Exec()
 	if err != nil {
-		return ""
+		return "", nil, err
 	}
 
-	return sessionID
+	return sessionID, session, nil
 }
 
-// ValidateSession checks if a session is valid and not expired
-func ValidateSession(sessionID string) error {
+// ValidateSession checks if a session is valid and not expired. Each use
+// slides the expiry forward, but never past the absolute session lifetime.
+func ValidateSession(sessionID string) (*Session, error) {
 	o := orm.NewOrm()
-	session := Session{Id: sessionID}
+	session := Session{Id: hashToken(sessionID)}
 
 	if err := o.Read(&session); err != nil {
-		return err
+		return nil, err
 	}
 
-	if time.Now().After(session.ExpiresAt) {
+	now := time.Now()
+	maxExpiry := session.CreatedAt.Add(config.SessionMaxAge)
+	if now.After(session.ExpiresAt) || now.After(maxExpiry) {
 		o.Delete(&session)
-		return orm.ErrNoRows
+		return nil, orm.ErrNoRows
 	}
 
 	// Extend session expiry
-	session.ExpiresAt = time.Now().Add(24 * time.Hour)
-	_, err := o.Update(&session, "ExpiresAt")
-	return err
+	session.ExpiresAt = now.Add(config.SessionTTL)
+	if session.ExpiresAt.After(maxExpiry) {
+		session.ExpiresAt = maxExpiry
+	}
+	if _, err := o.Update(&session, "ExpiresAt"); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
 }
 
-// LinkSessionToUser associates a session with a user
-func LinkSessionToUser(sessionID string, userID int) error {
-	o := orm.NewOrm()
-	session := Session{Id: sessionID}
+// ValidCSRFToken reports whether token matches the session's CSRF token
+func (s *Session) ValidCSRFToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(s.CsrfToken), []byte(token)) == 1
+}
 
-	if err := o.Read(&session); err != nil {
-		return err
-	}
+// DeleteSession removes the session identified by the cookie value
+func DeleteSession(sessionID string) error {
+	o := orm.NewOrm()
+	_, err := o.Delete(&Session{Id: hashToken(sessionID)})
+	return err
+}
 
-	session.UserId = userID
-	_, err := o.Update(&session, "UserId")
+// DeleteUserSessions removes every session of the user
+func DeleteUserSessions(userID string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`DELETE FROM session WHERE user_id = ?`, userID).Exec()
 	return err
 }
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// SigningKeyPropagationDelay is how long a new signing key is published
+// before it starts signing tokens. Every server reloads the keys more often
+// than this, so that none of them sees a token signed with a key it doesn't
+// know.
+const SigningKeyPropagationDelay = 5 * time.Minute
+
+// signingKeyLockID is the advisory lock held while rotating keys, so that
+// servers starting together create only one key
+const signingKeyLockID = 7346501
+
+// JWTSigningKey is a stored JWT signing key. A key signs tokens from
+// ActivatesAt until a newer key activates, and verifies them until
+// RetiresAt, after which it is deleted.
+type JWTSigningKey struct {
+	ID          string     `orm:"pk;size(32);column(id)" json:"id"`
+	Algorithm   string     `orm:"column(algorithm);size(16)" json:"algorithm"`
+	PrivateKey  string     `orm:"column(private_key);type(text)" json:"-"`
+	ActivatesAt time.Time  `orm:"column(activates_at);type(timestamp with time zone)" json:"activates_at"`
+	RetiresAt   *time.Time `orm:"column(retires_at);null;type(timestamp with time zone)" json:"retires_at"`
+	CreatedAt   time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (k *JWTSigningKey) TableName() string {
+	return "jwt_signing_keys"
+}
+
+// EnsureJWTSigningKeysTable creates the jwt_signing_keys table if it doesn't exist
+func EnsureJWTSigningKeysTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+		id VARCHAR(32) PRIMARY KEY,
+		algorithm VARCHAR(16) NOT NULL,
+		private_key TEXT NOT NULL,
+		activates_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		retires_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	o := orm.NewOrm()
+	_, err := o.Raw(sql).Exec()
+	return err
+}
+
+// LoadSigningKeys loads the keys that haven't retired into the key set that
+// signs and verifies tokens
+func LoadSigningKeys() error {
+	o := orm.NewOrm()
+	var stored []JWTSigningKey
+	_, err := o.Raw(`SELECT * FROM jwt_signing_keys WHERE retires_at IS NULL OR retires_at > ?`, time.Now()).
+		QueryRows(&stored)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*utils.SigningKey, 0, len(stored))
+	for _, k := range stored {
+		private, err := utils.ParsePrivateKeyPEM(k.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s: %v", k.ID, err)
+		}
+		keys = append(keys, &utils.SigningKey{
+			ID:          k.ID,
+			Algorithm:   k.Algorithm,
+			PrivateKey:  private,
+			ActivatesAt: k.ActivatesAt,
+		})
+	}
+	utils.SetSigningKeys(keys)
+	return nil
+}
+
+// RotateSigningKeys creates a new signing key if there is none, the newest
+// is older than JWT_KEY_ROTATION_INTERVAL or uses another algorithm than
+// JWT_SIGNING_ALGORITHM, or force is set. Older keys retire once the tokens
+// they signed have expired. It reports whether a key was created, and
+// reloads the keys either way.
+func RotateSigningKeys(force bool) (bool, error) {
+	rotated := false
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		if _, err := txOrm.Raw(`SELECT pg_advisory_xact_lock(?)`, signingKeyLockID).Exec(); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var latest JWTSigningKey
+		err := txOrm.Raw(`SELECT * FROM jwt_signing_keys WHERE retires_at IS NULL ORDER BY activates_at DESC LIMIT 1`).
+			QueryRow(&latest)
+		first := errors.Is(err, orm.ErrNoRows)
+		if err != nil && !first {
+			return err
+		}
+		if !first && !force && latest.Algorithm == config.JWTSigningAlgorithm &&
+			now.Sub(latest.ActivatesAt) < config.JWTKeyRotationInterval {
+			return nil
+		}
+
+		// The first key has no tokens to wait for
+		activatesAt := now
+		if !first {
+			activatesAt = now.Add(SigningKeyPropagationDelay)
+		}
+		key, err := utils.GenerateSigningKey(config.JWTSigningAlgorithm, activatesAt)
+		if err != nil {
+			return err
+		}
+		encoded, err := utils.EncodePrivateKeyPEM(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		statements := []struct {
+			sql  string
+			args []interface{}
+		}{
+			{`INSERT INTO jwt_signing_keys (id, algorithm, private_key, activates_at, created_at)
+			  VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`, []interface{}{key.ID, key.Algorithm, encoded, activatesAt}},
+			{`UPDATE jwt_signing_keys SET retires_at = ? WHERE retires_at IS NULL AND id <> ?`,
+				[]interface{}{activatesAt.Add(maxSignedTokenTTL()), key.ID}},
+			{`DELETE FROM jwt_signing_keys WHERE retires_at < ?`, []interface{}{now}},
+		}
+		for _, stmt := range statements {
+			if _, err := txOrm.Raw(stmt.sql, stmt.args...).Exec(); err != nil {
+				return err
+			}
+		}
+		rotated = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return rotated, LoadSigningKeys()
+}
+
+// maxSignedTokenTTL is the longest lifetime of a token signed with the keys
+func maxSignedTokenTTL() time.Duration {
+	if config.MFAPendingTTL > config.AccessTokenTTL {
+		return config.MFAPendingTTL
+	}
+	return config.AccessTokenTTL
+}
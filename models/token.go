@@ -0,0 +1,228 @@
+package models
+
+import (
+	"cbc-backend/config"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is
+	// presented again. The whole token family is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is a long-lived, single-use credential that is exchanged for a
+// new access token. Every token issued from the same login shares a FamilyID
+// so that the whole chain can be revoked at once.
+type RefreshToken struct {
+	ID        int64      `orm:"pk;auto;column(id)" json:"id"`
+	UserID    string     `orm:"column(user_id);size(36)" json:"user_id"`
+	FamilyID  string     `orm:"column(family_id);size(36)" json:"family_id"`
+	TokenHash string     `orm:"column(token_hash);size(64);unique" json:"-"`
+	MFA       bool       `orm:"column(mfa);default(false)" json:"mfa"`
+	ExpiresAt time.Time  `orm:"column(expires_at);type(timestamp with time zone)" json:"expires_at"`
+	RevokedAt *time.Time `orm:"column(revoked_at);null;type(timestamp with time zone)" json:"revoked_at"`
+	CreatedAt time.Time  `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (r *RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken records the ID (jti) of an access token that was revoked
+// before its natural expiry
+type RevokedToken struct {
+	Jti       string    `orm:"pk;column(jti);size(36)" json:"jti"`
+	ExpiresAt time.Time `orm:"column(expires_at);type(timestamp with time zone)" json:"expires_at"`
+	CreatedAt time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+}
+
+// TableName specifies the database table name
+func (r *RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// EnsureRefreshTokensTable creates the refresh_tokens table if it doesn't exist
+func EnsureRefreshTokensTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		family_id VARCHAR(36) NOT NULL,
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	o := orm.NewOrm()
+	if _, err := o.Raw(sql).Exec(); err != nil {
+		return err
+	}
+	if _, err := o.Raw(`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id)`).Exec(); err != nil {
+		return err
+	}
+	_, err := o.Raw(`ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE`).Exec()
+	return err
+}
+
+// EnsureRevokedTokensTable creates the revoked_tokens table if it doesn't exist
+func EnsureRevokedTokensTable() error {
+	sql := `
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti VARCHAR(36) PRIMARY KEY,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	o := orm.NewOrm()
+	_, err := o.Raw(sql).Exec()
+	return err
+}
+
+// hashToken returns the hex-encoded SHA-256 of an opaque token. Only the hash
+// is stored so that a database leak does not expose usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newOpaqueToken returns a random 256-bit hex token
+func newOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// IssueRefreshToken creates a new refresh token for the user in the given
+// family. Callers start a new family (i.e. a new login) with a fresh UUID.
+// mfa records whether the login completed two-factor authentication, so
+// that refreshed access tokens keep that status.
+func IssueRefreshToken(userID, familyID string, mfa bool) (string, error) {
+	return issueRefreshToken(orm.NewOrm(), userID, familyID, mfa)
+}
+
+func issueRefreshToken(o orm.QueryExecutor, userID, familyID string, mfa bool) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = o.Raw(`INSERT INTO refresh_tokens (user_id, family_id, token_hash, mfa, expires_at, created_at)
+					 VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		userID, familyID, hashToken(token), mfa, time.Now().Add(config.RefreshTokenTTL)).Exec()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. It returns the rotated record along with the new token. Presenting
+// a token that has already been rotated revokes the entire family, since it
+// means the token leaked.
+func RotateRefreshToken(token string) (*RefreshToken, string, error) {
+	var (
+		current  RefreshToken
+		newToken string
+		reused   bool
+	)
+
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		err := txOrm.Raw(`SELECT * FROM refresh_tokens WHERE token_hash = ? FOR UPDATE`, hashToken(token)).
+			QueryRow(&current)
+		if err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.RevokedAt != nil {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if _, err := txOrm.Raw(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?`, current.ID).Exec(); err != nil {
+			return err
+		}
+
+		newToken, err = issueRefreshToken(txOrm, current.UserID, current.FamilyID, current.MFA)
+		return err
+	})
+
+	if reused {
+		// Revoke outside the rolled back transaction
+		if revokeErr := RevokeRefreshTokenFamily(current.FamilyID); revokeErr != nil {
+			return nil, "", revokeErr
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &current, newToken, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token issued from the same login
+func RevokeRefreshTokenFamily(familyID string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+					 WHERE family_id = ? AND revoked_at IS NULL`, familyID).Exec()
+	return err
+}
+
+// RevokeAllRefreshTokens revokes every outstanding refresh token of a user
+func RevokeAllRefreshTokens(userID string) error {
+	o := orm.NewOrm()
+	_, err := o.Raw(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+					 WHERE user_id = ? AND revoked_at IS NULL`, userID).Exec()
+	return err
+}
+
+// RevokeAccessToken adds an access token ID to the revocation list until the
+// token would have expired anyway
+func RevokeAccessToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	o := orm.NewOrm()
+
+	// Expired entries can no longer be presented, so drop them while we're here
+	if _, err := o.Raw(`DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`).Exec(); err != nil {
+		return err
+	}
+
+	_, err := o.Raw(`INSERT INTO revoked_tokens (jti, expires_at, created_at)
+					 VALUES (?, ?, CURRENT_TIMESTAMP) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt).Exec()
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token ID is on the revocation list
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	o := orm.NewOrm()
+	var count int64
+	err := o.Raw(`SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).QueryRow(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// Kinds of items that go to the trash when deleted
+const (
+	TrashUser   = "user"
+	TrashJob    = "job"
+	TrashUpload = "upload"
+)
+
+// trashSources maps each kind to its table and the column shown as its name
+var trashSources = []struct {
+	kind, table, nameColumn string
+}{
+	{TrashUser, "users", "username"},
+	{TrashJob, "jobs", "title"},
+	{TrashUpload, "uploads", "file_name"},
+}
+
+// ErrUnknownTrashKind is returned for a kind other than user, job or upload
+var ErrUnknownTrashKind = errors.New("unknown trash item type")
+
+// TrashItem is a deleted user, job or upload waiting to be purged
+type TrashItem struct {
+	Type      string    `orm:"column(type)" json:"type"`
+	ID        string    `orm:"column(id)" json:"id"`
+	Name      string    `orm:"column(name)" json:"name"`
+	DeletedAt time.Time `orm:"column(deleted_at)" json:"deleted_at"`
+}
+
+// trashTable returns the table holding items of the kind
+func trashTable(kind string) (string, error) {
+	for _, source := range trashSources {
+		if source.kind == kind {
+			return source.table, nil
+		}
+	}
+	return "", ErrUnknownTrashKind
+}
+
+// moveToTrash marks the item deleted. It returns orm.ErrNoRows if there is
+// no such item outside the trash.
+func moveToTrash(kind, id string) error {
+	table, err := trashTable(kind)
+	if err != nil {
+		return err
+	}
+
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE `+table+` SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, id).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser moves the user to the trash and ends their sessions. The last
+// active admin can't be deleted.
+func DeleteUser(userID string) error {
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		var user User
+		err := txOrm.Raw(`SELECT * FROM users WHERE id = ? AND deleted_at IS NULL FOR UPDATE`, userID).QueryRow(&user)
+		if err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			if err := ensureOtherAdmin(txOrm, userID); err != nil {
+				return err
+			}
+		}
+		_, err = txOrm.Raw(`UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`, userID).Exec()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return SignOutEverywhere(userID)
+}
+
+// RestoreFromTrash undeletes the item. It returns orm.ErrNoRows if the item
+// isn't in the trash.
+func RestoreFromTrash(kind, id string) error {
+	table, err := trashTable(kind)
+	if err != nil {
+		return err
+	}
+
+	o := orm.NewOrm()
+	res, err := o.Raw(`UPDATE `+table+` SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id).Exec()
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// ListTrash returns a page of deleted items, most recently deleted first,
+// together with the total number of items. An empty kind lists every kind.
+func ListTrash(kind string, page, pageSize int) ([]TrashItem, int64, error) {
+	var selects []string
+	for _, source := range trashSources {
+		if kind == "" || kind == source.kind {
+			selects = append(selects, fmt.Sprintf(
+				`SELECT '%s' AS type, CAST(id AS TEXT) AS id, %s AS name, deleted_at FROM %s WHERE deleted_at IS NOT NULL`,
+				source.kind, source.nameColumn, source.table))
+		}
+	}
+	if len(selects) == 0 {
+		return nil, 0, ErrUnknownTrashKind
+	}
+	union := strings.Join(selects, " UNION ALL ")
+
+	o := orm.NewOrm()
+	var total int64
+	if err := o.Raw(`SELECT COUNT(*) FROM (` + union + `) AS trash`).QueryRow(&total); err != nil {
+		return nil, 0, err
+	}
+
+	items := []TrashItem{}
+	_, err := o.Raw(`SELECT * FROM (`+union+`) AS trash ORDER BY deleted_at DESC, id LIMIT ? OFFSET ?`,
+		pageSize, (page-1)*pageSize).QueryRows(&items)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// PurgeTrash permanently deletes items that were deleted before the cutoff
+// and returns how many were purged. Purging a user erases their data as
+// EraseUser does.
+func PurgeTrash(before time.Time) (int, error) {
+	o := orm.NewOrm()
+	purged := 0
+	recordPurge := func(kind, id string, err error) {
+		auditErr := RecordAuditEvent(&AuditEvent{
+			ActorName:  "scheduler",
+			Action:     AuditTrashPurged,
+			TargetType: kind,
+			TargetID:   id,
+			Result:     AuditResult(err),
+		})
+		if auditErr != nil {
+			fmt.Println(auditErr)
+		}
+		if err != nil {
+			fmt.Printf("Failed to purge %s %s: %v\n", kind, id, err)
+			return
+		}
+		purged++
+	}
+
+	// Users go first since erasing them also removes their uploads and jobs
+	var userIDs []string
+	if _, err := o.Raw(`SELECT id FROM users WHERE deleted_at < ?`, before).QueryRows(&userIDs); err != nil {
+		return purged, err
+	}
+	for _, id := range userIDs {
+		recordPurge(TrashUser, id, EraseUser(id))
+	}
+
+	var uploads []Upload
+	if _, err := o.Raw(`SELECT * FROM uploads WHERE deleted_at < ?`, before).QueryRows(&uploads); err != nil {
+		return purged, err
+	}
+	for _, upload := range uploads {
+		_, err := o.Raw(`DELETE FROM uploads WHERE id = ?`, upload.Id).Exec()
+		if err == nil {
+			if err := os.Remove(upload.FilePath); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Warning: Failed to remove %s: %v\n", upload.FilePath, err)
+			}
+		}
+		recordPurge(TrashUpload, upload.Id, err)
+	}
+
+	var jobIDs []string
+	if _, err := o.Raw(`SELECT CAST(id AS TEXT) FROM jobs WHERE deleted_at < ?`, before).QueryRows(&jobIDs); err != nil {
+		return purged, err
+	}
+	for _, id := range jobIDs {
+		_, err := o.Raw(`DELETE FROM jobs WHERE id = ?`, id).Exec()
+		recordPurge(TrashJob, id, err)
+	}
+	return purged, nil
+}
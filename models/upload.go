@@ -15,6 +15,9 @@ type Upload struct {
 	ContentType string    `orm:"column(content_type)" json:"content_type"`
 	UserID      string    `orm:"column(user_id);size(36)" json:"user_id"`
 	CreatedAt   time.Time `orm:"auto_now_add;type(timestamp with time zone);column(created_at)" json:"created_at"`
+	// DeletedAt is set while the upload is in the trash. Its file is kept
+	// until the upload is purged.
+	DeletedAt *time.Time `orm:"null;type(timestamp with time zone);column(deleted_at)" json:"deleted_at,omitempty"`
 }
 
 // TableName specifies the database table name
@@ -24,7 +27,7 @@ func (u *Upload) TableName() string {
 
 // EnsureUploadsTable creates the uploads table if it doesn't exist
 func EnsureUploadsTable() error {
-	sql := `
+	statements := []string{`
 	CREATE TABLE IF NOT EXISTS uploads (
 		id VARCHAR(36) PRIMARY KEY,
 		file_name VARCHAR(255) NOT NULL,
@@ -33,20 +36,30 @@ func EnsureUploadsTable() error {
 		content_type VARCHAR(100),
 		user_id VARCHAR(36) NOT NULL REFERENCES users(id),
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-	)`
+	)`,
+		`ALTER TABLE uploads ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE`,
+	}
 
-	// Drop existing table if it exists
-	dropSQL := `DROP TABLE IF EXISTS uploads`
 	o := orm.NewOrm()
-
-	// Drop and recreate
-	_, err := o.Raw(dropSQL).Exec()
-	if err != nil {
-		return err
+	for _, sql := range statements {
+		if _, err := o.Raw(sql).Exec(); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	_, err = o.Raw(sql).Exec()
-	return err
+// GetUpload retrieves an upload by ID. Uploads in the trash aren't found.
+func GetUpload(id string) (*Upload, error) {
+	o := orm.NewOrm()
+	upload := &Upload{}
+	err := o.QueryTable("uploads").Filter("id", id).Filter("deleted_at__isnull", true).One(upload)
+	return upload, err
+}
+
+// DeleteUpload moves an upload to the trash
+func DeleteUpload(id string) error {
+	return moveToTrash(TrashUpload, id)
 }
 
 // CreateUpload creates a new upload record
@@ -3,24 +3,36 @@ package models
 import (
 	"cbc-backend/config"
 	"cbc-backend/utils"
-	"errors"
 	"fmt"
 	"time"
 
 	"github.com/beego/beego/v2/client/orm"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user in the system
 type User struct {
-	ID        string    `orm:"pk;size(36);column(id)" json:"id"`
-	Username  string    `orm:"unique;size(128)" json:"username"`
-	Password  string    `orm:"size(128)" json:"-"`
-	Email     string    `orm:"size(128);unique" json:"email"`
-	Role      string    `orm:"size(20)" json:"role"` // admin, teacher, student
-	CreatedAt time.Time `orm:"auto_now_add;type(timestamp)" json:"created_at"`
+	ID         string     `orm:"pk;size(36);column(id)" json:"id"`
+	Username   string     `orm:"unique;size(128)" json:"username"`
+	Password   string     `orm:"size(128)" json:"-"`
+	Email      string     `orm:"size(128);unique" json:"email"`
+	Role       Role       `orm:"size(20)" json:"role"`
+	VerifiedAt *time.Time `orm:"null;type(timestamp with time zone);column(verified_at)" json:"verified_at"`
+	CreatedAt  time.Time  `orm:"auto_now_add;type(timestamp)" json:"created_at"`
+
+	// Suspended users can't sign in; SuspendedReason is shown to admins
+	SuspendedAt     *time.Time `orm:"null;type(timestamp with time zone);column(suspended_at)" json:"suspended_at"`
+	SuspendedReason string     `orm:"size(500);column(suspended_reason)" json:"suspended_reason,omitempty"`
+	// PasswordResetRequired blocks password logins until the user resets
+	// their password
+	PasswordResetRequired bool `orm:"default(false);column(password_reset_required)" json:"password_reset_required"`
+	// ErasureScheduledAt is when the account and its data will be erased,
+	// if its deletion was requested
+	ErasureScheduledAt *time.Time `orm:"null;type(timestamp with time zone);column(erasure_scheduled_at)" json:"erasure_scheduled_at"`
+	// DeletedAt is set while the user is in the trash. Deleted users are
+	// left out of lookups and listings and can't sign in.
+	DeletedAt *time.Time `orm:"null;type(timestamp with time zone);column(deleted_at)" json:"deleted_at,omitempty"`
 }
 
 // TableName specifies the database table name
@@ -28,12 +40,21 @@ func (u *User) TableName() string {
 	return "users"
 }
 
+// HashPassword returns the bcrypt hash of a password using the configured cost
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
 func (u *User) HashPassword() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := HashPassword(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
@@ -41,23 +62,20 @@ func (u *User) ValidatePassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 }
 
-func (u *User) GenerateToken() (string, error) {
-	claims := jwt.MapClaims{
-		"user_id":  u.ID,
-		"username": u.Username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
-		"iat":      time.Now().Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(config.JWTSecret)
+// GetUserByUsername retrieves a user by username. Users in the trash aren't
+// found.
+func GetUserByUsername(username string) (*User, error) {
+	o := orm.NewOrm()
+	user := &User{}
+	err := o.QueryTable("users").Filter("username", username).Filter("deleted_at__isnull", true).One(user)
+	return user, err
 }
 
-// GetUserByUsername retrieves a user by username
-func GetUserByUsername(username string) (*User, error) {
+// GetUserByID retrieves a user by ID. Users in the trash aren't found.
+func GetUserByID(userID string) (*User, error) {
 	o := orm.NewOrm()
-	user := &User{Username: username}
-	err := o.Read(user, "Username")
+	user := &User{}
+	err := o.QueryTable("users").Filter("id", userID).Filter("deleted_at__isnull", true).One(user)
 	return user, err
 }
 
@@ -68,34 +86,42 @@ func CreateUser(user *User) error {
 
 	o := orm.NewOrm()
 	// Use Raw instead of Insert to avoid LastInsertId issue
-	_, err := o.Raw(`INSERT INTO users (id, username, password, email, role, created_at) 
-					 VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
-		user.ID, user.Username, user.Password, user.Email, user.Role).Exec()
+	_, err := o.Raw(`INSERT INTO users (id, username, password, email, role, verified_at, created_at)
+					 VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		user.ID, user.Username, user.Password, user.Email, user.Role, user.VerifiedAt).Exec()
 	return err
 }
 
-func UpdateUser(user *User) error {
+// SetPassword checks the new password against the policy, stores it and
+// signs the user out everywhere
+func SetPassword(user *User, newPassword string) error {
+	if err := utils.CheckPasswordPolicy(newPassword, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
 	o := orm.NewOrm()
-	_, err := o.Update(user)
-	return err
+	_, err = o.Raw(`UPDATE users SET password = ?, password_reset_required = FALSE WHERE id = ?`,
+		hashedPassword, user.ID).Exec()
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	user.PasswordResetRequired = false
+
+	return SignOutEverywhere(user.ID)
 }
 
-func DeleteUser(username string) error {
+func UpdateUser(user *User) error {
 	o := orm.NewOrm()
-	_, err := o.Delete(&User{Username: username})
+	_, err := o.Update(user)
 	return err
 }
 
-func ValidateToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return config.JWTSecret, nil
-	})
-	return token, err
-}
-
 func EnsureUsersTable() error {
 	sql := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -103,143 +129,105 @@ func EnsureUsersTable() error {
 		username VARCHAR(128) UNIQUE NOT NULL,
 		password VARCHAR(128) NOT NULL,
 		email VARCHAR(128) UNIQUE,
-		role VARCHAR(20) DEFAULT 'user',
+		role VARCHAR(20) DEFAULT 'teacher',
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 	)`
 
 	o := orm.NewOrm()
-	_, err := o.Raw(sql).Exec()
-	return err
-}
-
-// PasswordReset represents a password reset request
-type PasswordReset struct {
-	ID        int    `orm:"pk;auto;column(id)"`
-	UserID    string `orm:"column(user_id);size(36)"`
-	Token     string `orm:"size(100)"`
-	ExpiresAt time.Time
-	Used      bool      `orm:"default(false)"`
-	CreatedAt time.Time `orm:"auto_now_add;type(datetime)"`
-}
-
-func CreatePasswordReset(email string) (string, error) {
-	// Ensure password reset table exists first
-	if err := EnsurePasswordResetTable(); err != nil {
-		return "", fmt.Errorf("failed to create password reset table: %v", err)
-	}
-
-	o := orm.NewOrm()
-
-	// Find user by email
-	var user User
-	if err := o.QueryTable("users").Filter("email", email).One(&user); err != nil {
-		return "", fmt.Errorf("user not found")
-	}
-
-	// Generate reset token
-	token := utils.GenerateRandomString(32)
-
-	// Create reset record
-	reset := &PasswordReset{
-		UserID:    user.ID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour), // Token valid for 24 hours
-	}
-
-	_, err := o.Insert(reset)
-	if err != nil {
-		return "", err
-	}
-
-	return token, nil
-}
-
-func ResetPassword(token, newPassword string) error {
-	o := orm.NewOrm()
-
-	// Find valid reset token
-	var reset PasswordReset
-	err := o.QueryTable("password_resets").
-		Filter("token", token).
-		Filter("expires_at__gt", time.Now()).
-		Filter("used", false).
-		One(&reset)
-
-	if err != nil {
-		return fmt.Errorf("invalid or expired reset token")
-	}
-
-	// Get user
-	var user User
-	if err := o.QueryTable("users").Filter("id", reset.UserID).One(&user); err != nil {
+	if _, err := o.Raw(sql).Exec(); err != nil {
 		return err
 	}
 
-	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+	// Accounts created before roles were enforced used the generic 'user'
+	// role, which had the same access teachers have now
+	migrations := []string{
+		fmt.Sprintf(`UPDATE users SET role = '%s' WHERE role IS NULL OR role NOT IN (%s)`, DefaultRole, roleCheckSQL()),
+		fmt.Sprintf(`ALTER TABLE users ALTER COLUMN role SET DEFAULT '%s'`, DefaultRole),
+		`ALTER TABLE users ALTER COLUMN role SET NOT NULL`,
+		`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check`,
+		fmt.Sprintf(`ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN (%s))`, roleCheckSQL()),
+		// Accounts from before email verification count as verified, so that
+		// they keep uploading and posting jobs. Only done when the column is
+		// first added, as later accounts start out unverified.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+						   WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'verified_at') THEN
+				ALTER TABLE users ADD COLUMN verified_at TIMESTAMP WITH TIME ZONE;
+				UPDATE users SET verified_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE verified_at IS NULL;
+			END IF;
+		END $$`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_reason VARCHAR(500) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS erasure_scheduled_at TIMESTAMP WITH TIME ZONE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE`,
 	}
-
-	// Update password
-	user.Password = string(hashedPassword)
-	if _, err := o.Update(&user, "Password"); err != nil {
-		return err
+	for _, migration := range migrations {
+		if _, err := o.Raw(migration).Exec(); err != nil {
+			return err
+		}
 	}
-
-	// Mark token as used
-	reset.Used = true
-	_, err = o.Update(&reset, "Used")
-	return err
+	return nil
 }
 
-func EnsurePasswordResetTable() error {
-	sql := `
-	CREATE TABLE IF NOT EXISTS password_resets (
-		id SERIAL PRIMARY KEY,
-		user_id VARCHAR(36) REFERENCES users(id),
-		token VARCHAR(100) NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		used BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	o := orm.NewOrm()
-	_, err := o.Raw(sql).Exec()
-	return err
-}
+// PasswordResetTTL is how long a password reset link stays valid
+const PasswordResetTTL = 24 * time.Hour
 
-// DeleteUserByID deletes a user by their ID
-func DeleteUserByID(userID string) error {
+// CreatePasswordReset creates a reset token for the user with the given
+// email and returns the user together with the token
+func CreatePasswordReset(email string) (*User, string, error) {
 	o := orm.NewOrm()
 
-	// Delete password resets first using raw SQL
-	_, err := o.Raw("DELETE FROM password_resets WHERE user_id = ?", userID).Exec()
-	if err != nil {
-		fmt.Printf("Warning: Failed to delete password resets: %v\n", err)
+	// Find user by email
+	var user User
+	if err := o.QueryTable("users").Filter("email", email).Filter("deleted_at__isnull", true).One(&user); err != nil {
+		return nil, "", fmt.Errorf("user not found")
 	}
 
-	// Delete user
-	_, err = o.Delete(&User{ID: userID})
+	token, err := IssueOneTimeToken(TokenPasswordReset, user.ID, "", PasswordResetTTL)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
+	return &user, token, nil
+}
+
+// ResetPassword sets a new password using a reset token. It returns the
+// token's user, if it was found, even when the reset fails. The token stays
+// valid if the new password is rejected. Like SetPassword, a reset signs the
+// user out everywhere, so that whoever knew the old password loses access,
+// and lifts any login lockout.
+func ResetPassword(token, newPassword string) (*User, error) {
+	var user *User
+	err := ConsumeOneTimeToken(TokenPasswordReset, token, func(txOrm orm.TxOrmer, t *OneTimeToken) error {
+		if t.UserID == nil {
+			return ErrInvalidOneTimeToken
+		}
+		var found User
+		if err := txOrm.QueryTable("users").Filter("id", *t.UserID).Filter("deleted_at__isnull", true).One(&found); err != nil {
+			return ErrInvalidOneTimeToken
+		}
+		user = &found
 
-	return nil
-}
-
-// PromoteUserToAdmin promotes a user to admin role
-func PromoteUserToAdmin(userID string) error {
-	o := orm.NewOrm()
+		if err := utils.CheckPasswordPolicy(newPassword, user.Username, user.Email); err != nil {
+			return err
+		}
+		hashedPassword, err := HashPassword(newPassword)
+		if err != nil {
+			return err
+		}
 
-	// Get user
-	user := &User{ID: userID}
-	if err := o.Read(user); err != nil {
+		user.Password = hashedPassword
+		user.PasswordResetRequired = false
+		_, err = txOrm.Update(user, "Password", "PasswordResetRequired")
 		return err
+	})
+	if err != nil {
+		return user, err
 	}
 
-	// Update role
-	user.Role = "admin"
-	_, err := o.Update(user, "Role")
-	return err
+	if err := SignOutEverywhere(user.ID); err != nil {
+		return user, err
+	}
+	return user, UnlockLogin(user.Username)
 }
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ErrLastAdmin is returned when a change would leave no active admin
+var ErrLastAdmin = errors.New("cannot remove the last active admin")
+
+// UserFilter narrows ListUsers. Zero values don't filter.
+type UserFilter struct {
+	Role          Role
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	EmailDomain   string
+	Verified      *bool
+	Suspended     *bool
+}
+
+// ListUsers returns a page of users matching the filter, newest first,
+// together with the total number of matches
+func ListUsers(filter UserFilter, page, pageSize int) ([]User, int64, error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable("users").Filter("deleted_at__isnull", true)
+
+	if filter.Role != "" {
+		qs = qs.Filter("role", filter.Role)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		qs = qs.Filter("created_at__gte", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		qs = qs.Filter("created_at__lt", filter.CreatedBefore)
+	}
+	if filter.EmailDomain != "" {
+		qs = qs.Filter("email__iendswith", "@"+strings.TrimPrefix(filter.EmailDomain, "@"))
+	}
+	if filter.Verified != nil {
+		qs = qs.Filter("verified_at__isnull", !*filter.Verified)
+	}
+	if filter.Suspended != nil {
+		qs = qs.Filter("suspended_at__isnull", !*filter.Suspended)
+	}
+
+	total, err := qs.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	_, err = qs.OrderBy("-created_at").Limit(pageSize).Offset((page - 1) * pageSize).All(&users)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SetUserRole changes a user's role. Demoting or suspending the last active
+// admin is refused so that the admin API stays reachable. The user is signed
+// out everywhere, since their tokens carry the old role.
+func SetUserRole(userID string, role Role) (*User, error) {
+	var user User
+	changed := false
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		if err := txOrm.Raw(`SELECT * FROM users WHERE id = ? FOR UPDATE`, userID).QueryRow(&user); err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin && role != RoleAdmin {
+			if err := ensureOtherAdmin(txOrm, userID); err != nil {
+				return err
+			}
+		}
+
+		changed = user.Role != role
+		user.Role = role
+		_, err := txOrm.Raw(`UPDATE users SET role = ? WHERE id = ?`, role, userID).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return &user, nil
+	}
+	return &user, SignOutEverywhere(userID)
+}
+
+// ensureOtherAdmin returns ErrLastAdmin unless an active admin other than
+// userID exists. Admin rows are locked so concurrent demotions can't both
+// succeed.
+func ensureOtherAdmin(o orm.QueryExecutor, userID string) error {
+	var ids []string
+	_, err := o.Raw(`SELECT id FROM users WHERE role = ? AND suspended_at IS NULL AND erasure_scheduled_at IS NULL
+						   AND deleted_at IS NULL AND id <> ? FOR UPDATE`,
+		RoleAdmin, userID).QueryRows(&ids)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// CheckNotLastAdmin returns ErrLastAdmin if userID is the only active admin
+func CheckNotLastAdmin(userID string) error {
+	return ensureOtherAdmin(orm.NewOrm(), userID)
+}
+
+// SuspendUser blocks the user from signing in and ends their sessions
+func SuspendUser(userID, reason string) (*User, error) {
+	var user User
+	o := orm.NewOrm()
+	err := o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
+		if err := txOrm.Raw(`SELECT * FROM users WHERE id = ? FOR UPDATE`, userID).QueryRow(&user); err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			if err := ensureOtherAdmin(txOrm, userID); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		user.SuspendedAt = &now
+		user.SuspendedReason = reason
+		_, err := txOrm.Raw(`UPDATE users SET suspended_at = ?, suspended_reason = ? WHERE id = ?`,
+			now, reason, userID).Exec()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, SignOutEverywhere(userID)
+}
+
+// ReactivateUser lifts a suspension. The reason is kept for the record.
+func ReactivateUser(userID, reason string) (*User, error) {
+	o := orm.NewOrm()
+	_, err := o.Raw(`UPDATE users SET suspended_at = NULL, suspended_reason = ? WHERE id = ?`, reason, userID).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return GetUserByID(userID)
+}
+
+// RequirePasswordReset stops the user's current password from working until
+// they reset it, and ends their sessions
+func RequirePasswordReset(userID string) error {
+	o := orm.NewOrm()
+	if _, err := o.Raw(`UPDATE users SET password_reset_required = TRUE WHERE id = ?`, userID).Exec(); err != nil {
+		return err
+	}
+	return SignOutEverywhere(userID)
+}
+
+// SignOutEverywhere signs out all of the user's devices, ending their
+// sessions and revoking their refresh tokens. Access tokens already issued
+// stop being accepted along with their device.
+func SignOutEverywhere(userID string) error {
+	if err := DeleteUserDevices(userID); err != nil {
+		return err
+	}
+	if err := DeleteUserSessions(userID); err != nil {
+		return err
+	}
+	return RevokeAllRefreshTokens(userID)
+}
+
+// IsUserActive reports whether the user exists and isn't suspended or in
+// the trash
+func IsUserActive(userID string) (bool, error) {
+	o := orm.NewOrm()
+	var count int64
+	err := o.Raw(`SELECT COUNT(*) FROM users WHERE id = ? AND suspended_at IS NULL AND deleted_at IS NULL`,
+		userID).QueryRow(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// EmailVerificationTTL is how long an email verification link stays valid
+const EmailVerificationTTL = 48 * time.Hour
+
+// CreateEmailVerification creates a verification token for the user. Any
+// earlier unused tokens stop working so that only the latest email is valid.
+func CreateEmailVerification(userID string) (string, error) {
+	return IssueOneTimeToken(TokenEmailVerification, userID, "", EmailVerificationTTL)
+}
+
+// VerifyEmail consumes a verification token and marks the user's email as verified
+func VerifyEmail(token string) (*User, error) {
+	var user User
+	err := ConsumeOneTimeToken(TokenEmailVerification, token, func(txOrm orm.TxOrmer, t *OneTimeToken) error {
+		if t.UserID == nil {
+			return ErrInvalidOneTimeToken
+		}
+		if _, err := txOrm.Raw(`UPDATE users SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP) WHERE id = ?`, *t.UserID).Exec(); err != nil {
+			return err
+		}
+		return txOrm.Raw(`SELECT * FROM users WHERE id = ?`, *t.UserID).QueryRow(&user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// IsUserVerified reports whether the user has confirmed their email address
+func IsUserVerified(userID string) (bool, error) {
+	o := orm.NewOrm()
+	var count int64
+	err := o.Raw(`SELECT COUNT(*) FROM users WHERE id = ? AND verified_at IS NOT NULL`, userID).QueryRow(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -6,88 +6,162 @@ package routers
 import (
 	"cbc-backend/controllers"
 	"cbc-backend/middleware"
+	"cbc-backend/models"
 
 	"fmt"
+	"strings"
 
 	beego "github.com/beego/beego/v2/server/web"
-	"github.com/beego/beego/v2/server/web/context"
 )
 
+// route declares an endpoint together with the access rule enforced for it
+type route struct {
+	method     string
+	pattern    string
+	controller beego.ControllerInterface
+	handler    string
+	rule       middleware.Rule
+}
+
 func init() {
 	fmt.Println("\n=== Route Configuration ===")
 
 	// Add error handling middleware first
 	beego.InsertFilter("/*", beego.BeforeRouter, middleware.ErrorMiddleware)
 
+	// Add logger middleware for all routes
+	beego.InsertFilter("/*", beego.BeforeRouter, middleware.LoggerMiddleware)
+
+	// Allow cross-origin requests from the configured frontends only, as
+	// they carry the session cookie
+	beego.InsertFilter("/*", beego.BeforeRouter, middleware.CORS)
+
 	// Configure routes
-	configureUserRoutes()
-	configureResourceRoutes()
-	configureJobRoutes()
-
-	// Print route summary
-	fmt.Println("\nAvailable Endpoints:")
-	fmt.Printf("Auth:\n")
-	fmt.Printf("  POST /v1/user/signup\n")
-	fmt.Printf("  POST /v1/user/login\n")
-	fmt.Printf("  GET  /v1/user/logout\n")
-	fmt.Printf("  POST /v1/user/forgot-password\n")
-	fmt.Printf("  POST /v1/user/reset-password\n")
-	fmt.Printf("  DELETE /v1/user/:uid [Protected]\n")
-	fmt.Printf("\nResources:\n")
-	fmt.Printf("  GET  /v1/resources? [params] [public]\n")
-	fmt.Printf("  POST /v1/resources [Protected]\n")
-	fmt.Printf("\nJobs:\n")
-	fmt.Printf("  GET  /v1/jobs?[params] [Protected]\n")
-	fmt.Printf("  POST /v1/jobs [Protected]\n")
+	configureRoutes("Well-known", wellKnownRoutes())
+	configureRoutes("Auth", userRoutes())
+	configureRoutes("Resources", resourceRoutes())
+	configureRoutes("Jobs", jobRoutes())
+	configureRoutes("Organizations", organizationRoutes())
+	configureRoutes("Admin", adminRoutes())
 
 	fmt.Println("\n=== Route Configuration Complete ===")
 }
 
-func configureUserRoutes() {
-	// User routes
-	beego.Router("/v1/user/signup", &controllers.UserController{}, "post:Post")
-	beego.Router("/v1/user/login", &controllers.UserController{}, "post:Login")
-	beego.Router("/v1/user/logout", &controllers.UserController{}, "get:Logout")
-	beego.Router("/v1/user/reset-password", &controllers.UserController{}, "post:ResetPassword")
-	beego.Router("/v1/user/forgot-password", &controllers.UserController{}, "post:ForgotPassword")
-	beego.Router("/v1/user/:uid", &controllers.UserController{}, "delete:Delete")
-
-	// Add JWT middleware only for POST /v1/resources
-	beego.InsertFilter("/v1/resources", beego.BeforeRouter, func(ctx *context.Context) {
-		// Skip JWT check for GET requests
-		if ctx.Input.Method() == "GET" {
-			return
-		}
-		if ctx.Input.Method() == "POST" {
-			middleware.JWTMiddleware(ctx)
-		}
-	})
-
-	// Add JWT middleware for POST /v1/jobs
-	beego.InsertFilter("/v1/jobs", beego.BeforeRouter, func(ctx *context.Context) {
-		// Protect both GET and POST methods
-		if ctx.Input.Method() == "GET" || ctx.Input.Method() == "POST" {
-			middleware.JWTMiddleware(ctx)
-		}
-	})
+// configureRoutes registers each route and its access policy, and prints
+// the route summary
+func configureRoutes(group string, routes []route) {
+	fmt.Printf("\n%s:\n", group)
+	for _, r := range routes {
+		beego.Router(r.pattern, r.controller, strings.ToLower(r.method)+":"+r.handler)
+		beego.Policy(r.pattern, r.method, middleware.Authorize(r.rule))
+		fmt.Printf("  %-6s %s [%s]\n", r.method, r.pattern, r.rule)
+	}
+}
+
+func wellKnownRoutes() []route {
+	wellKnown := &controllers.WellKnownController{}
+	return []route{
+		{"GET", "/.well-known/jwks.json", wellKnown, "JWKS", middleware.Public},
+	}
+}
 
-	// Add logger middleware for all routes
-	beego.InsertFilter("/*", beego.BeforeRouter, middleware.LoggerMiddleware)
+func userRoutes() []route {
+	user := &controllers.UserController{}
+	return []route{
+		{"POST", "/v1/user/signup", user, "Post", middleware.Public},
+		{"POST", "/v1/user/login", user, "Login", middleware.Public},
+		{"POST", "/v1/user/login/mfa", user, "LoginMFA", middleware.Public},
+		{"GET", "/v1/user/oidc/login", user, "OIDCLogin", middleware.Public},
+		{"GET", "/v1/user/oidc/callback", user, "OIDCCallback", middleware.Public},
+		{"POST", "/v1/user/refresh", user, "Refresh", middleware.Public},
+		{"POST", "/v1/user/logout", user, "Logout", middleware.AuthenticatedWithoutMFA},
+		{"GET", "/v1/user/verify", user, "Verify", middleware.Public},
+		{"POST", "/v1/user/verify/resend", user, "ResendVerification", middleware.AuthenticatedWithoutMFA},
+		// Enrollment stays reachable for users whose role requires 2FA
+		{"POST", "/v1/user/mfa/enroll", user, "EnrollMFA", middleware.AuthenticatedWithoutMFA},
+		{"POST", "/v1/user/mfa/confirm", user, "ConfirmMFA", middleware.AuthenticatedWithoutMFA},
+		{"POST", "/v1/user/mfa/disable", user, "DisableMFA", middleware.Authenticated},
+		{"POST", "/v1/user/forgot-password", user, "ForgotPassword", middleware.Public},
+		{"POST", "/v1/user/reset-password", user, "ResetPassword", middleware.Public},
+		{"GET", "/v1/user/me", user, "GetMe", middleware.Authenticated},
+		{"PUT", "/v1/user/me", user, "UpdateMe", middleware.Authenticated},
+		{"GET", "/v1/user/me/export", user, "ExportMyData", middleware.Authenticated},
+		{"DELETE", "/v1/user/me/erasure", user, "CancelErasure", middleware.Authenticated},
+		{"GET", "/v1/user/me/sessions", user, "ListMySessions", middleware.Authenticated},
+		{"DELETE", "/v1/user/me/sessions/:id", user, "SignOutMySession", middleware.Authenticated},
+		{"GET", "/v1/user/:uid", user, "GetProfile", middleware.Authenticated},
+		// Users may delete themselves; the handler lets admins delete anyone
+		{"DELETE", "/v1/user/:uid", user, "Delete", middleware.Authenticated},
+	}
+}
+
+func resourceRoutes() []route {
+	resource := &controllers.ResourceController{}
+	return []route{
+		{"GET", "/v1/resources", resource, "Get", middleware.Public},
+		{"GET", "/v1/resources/:id", resource, "GetOne", middleware.Public},
+		{"GET", "/v1/resources/:id/download", resource, "Download", middleware.Public},
+		{"POST", "/v1/resources", resource, "Post", middleware.RequirePermissions(models.PermResourcesWrite)},
+		{"DELETE", "/v1/resources/uploads/:id", resource, "DeleteUpload", middleware.RequirePermissions(models.PermResourcesWrite)},
+	}
+}
 
-	// Add JWT middleware for delete user endpoint
-	beego.InsertFilter("/v1/user/*", beego.BeforeRouter, func(ctx *context.Context) {
-		if ctx.Input.Method() == "DELETE" {
-			middleware.JWTMiddleware(ctx)
-		}
-	})
+func jobRoutes() []route {
+	job := &controllers.JobController{}
+	return []route{
+		{"GET", "/v1/jobs", job, "Get", middleware.RequirePermissions(models.PermJobsRead)},
+		{"POST", "/v1/jobs", job, "Post", middleware.RequirePermissions(models.PermJobsWrite)},
+		{"GET", "/v1/jobs/:id", job, "GetOne", middleware.RequirePermissions(models.PermJobsRead)},
+		{"PUT", "/v1/jobs/:id", job, "Put", middleware.RequirePermissions(models.PermJobsWrite)},
+		{"DELETE", "/v1/jobs/:id", job, "Delete", middleware.RequirePermissions(models.PermJobsWrite)},
+	}
 }
 
-func configureResourceRoutes() {
-	// Resource routes
-	beego.Router("/v1/resources", &controllers.ResourceController{})
+// organizationRoutes only require a signed-in user; the handlers check the
+// caller's role in the organization
+func organizationRoutes() []route {
+	org := &controllers.OrganizationController{}
+	return []route{
+		{"POST", "/v1/organizations", org, "Create", middleware.Authenticated},
+		{"GET", "/v1/organizations", org, "List", middleware.Authenticated},
+		{"GET", "/v1/organizations/:oid", org, "GetOne", middleware.Authenticated},
+		{"PUT", "/v1/organizations/:oid", org, "Update", middleware.Authenticated},
+		{"DELETE", "/v1/organizations/:oid", org, "Delete", middleware.Authenticated},
+		{"GET", "/v1/organizations/:oid/members", org, "ListMembers", middleware.Authenticated},
+		{"PUT", "/v1/organizations/:oid/members/:uid", org, "ChangeMemberRole", middleware.Authenticated},
+		{"DELETE", "/v1/organizations/:oid/members/:uid", org, "RemoveMember", middleware.Authenticated},
+		{"GET", "/v1/organizations/:oid/invitations", org, "ListInvitations", middleware.Authenticated},
+		{"POST", "/v1/organizations/:oid/invitations", org, "Invite", middleware.Authenticated},
+		{"DELETE", "/v1/organizations/:oid/invitations/:id", org, "RevokeInvitation", middleware.Authenticated},
+		{"GET", "/v1/organizations/:oid/uploads", org, "ListUploads", middleware.Authenticated},
+		{"GET", "/v1/organizations/:oid/jobs", org, "ListJobs", middleware.Authenticated},
+		{"POST", "/v1/invitations/accept", org, "AcceptInvitation", middleware.Authenticated},
+		{"POST", "/v1/invitations/decline", org, "DeclineInvitation", middleware.Public},
+	}
 }
 
-func configureJobRoutes() {
-	// Jobs routes
-	beego.Router("/v1/jobs", &controllers.JobController{})
+func adminRoutes() []route {
+	admin := &controllers.AdminController{}
+	user := &controllers.UserController{}
+	adminOnly := middleware.RequireRoles(models.RoleAdmin)
+	return []route{
+		{"GET", "/v1/admin/users", admin, "ListUsers", adminOnly},
+		{"GET", "/v1/admin/users/:uid", admin, "GetUser", adminOnly},
+		{"DELETE", "/v1/admin/users/:uid", admin, "DeleteUser", adminOnly},
+		{"PUT", "/v1/admin/users/:uid/role", admin, "ChangeRole", adminOnly},
+		{"POST", "/v1/admin/users/:uid/promote", user, "PromoteToAdmin", adminOnly},
+		{"POST", "/v1/admin/users/:uid/suspend", admin, "SuspendUser", adminOnly},
+		{"POST", "/v1/admin/users/:uid/reactivate", admin, "ReactivateUser", adminOnly},
+		{"POST", "/v1/admin/users/:uid/force-password-reset", admin, "ForcePasswordReset", adminOnly},
+		{"POST", "/v1/admin/users/:uid/unlock", admin, "UnlockUser", adminOnly},
+		{"POST", "/v1/admin/users/:uid/sign-out", admin, "SignOutEverywhere", adminOnly},
+		{"DELETE", "/v1/admin/users/:uid/erasure", admin, "CancelErasure", adminOnly},
+		{"GET", "/v1/admin/api-keys", admin, "ListAPIKeys", adminOnly},
+		{"POST", "/v1/admin/api-keys", admin, "CreateAPIKey", adminOnly},
+		{"DELETE", "/v1/admin/api-keys/:id", admin, "RevokeAPIKey", adminOnly},
+		{"GET", "/v1/admin/trash", admin, "ListTrash", adminOnly},
+		{"POST", "/v1/admin/trash/:type/:id/restore", admin, "RestoreFromTrash", adminOnly},
+		{"GET", "/v1/admin/audit-events", admin, "ListAuditEvents", adminOnly},
+		{"GET", "/v1/admin/audit-events/export", admin, "ExportAuditEvents", adminOnly},
+	}
 }
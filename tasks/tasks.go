@@ -0,0 +1,75 @@
+// Package tasks runs the periodic background jobs of the server
+package tasks
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/beego/beego/v2/task"
+)
+
+// Specs use the six-field cron syntax of beego's task package, starting
+// with seconds
+const (
+	eraseUsersSpec = "0 */15 * * * *"
+	purgeTrashSpec = "0 0 3 * * *"
+	// Must run more often than models.SigningKeyPropagationDelay
+	signingKeysSpec = "0 * * * * *"
+	// Picks up resources added by the crawler
+	resourceMetadataSpec = "0 */5 * * * *"
+)
+
+// Init registers the periodic tasks and starts running them
+func Init() {
+	task.AddTask("erase_users", task.NewTask("erase_users", eraseUsersSpec, eraseUsers))
+	task.AddTask("purge_trash", task.NewTask("purge_trash", purgeTrashSpec, purgeTrash))
+	task.AddTask("signing_keys", task.NewTask("signing_keys", signingKeysSpec, rotateSigningKeys))
+	task.AddTask("resource_metadata", task.NewTask("resource_metadata", resourceMetadataSpec, extractResourceMetadata))
+	task.StartTask()
+}
+
+// eraseUsers erases the accounts whose deletion grace period has passed
+func eraseUsers(ctx context.Context) error {
+	erased, err := models.EraseDueUsers()
+	if err != nil {
+		return err
+	}
+	if erased > 0 {
+		fmt.Printf("Erased %d user account(s)\n", erased)
+	}
+	return nil
+}
+
+// purgeTrash permanently deletes what has been in the trash for longer than
+// the retention window
+func purgeTrash(ctx context.Context) error {
+	purged, err := models.PurgeTrash(time.Now().Add(-config.TrashRetention))
+	if purged > 0 {
+		fmt.Printf("Purged %d item(s) from the trash\n", purged)
+	}
+	return err
+}
+
+// rotateSigningKeys creates a new JWT signing key when rotation is due and
+// reloads the keys, picking up keys created by other servers
+func rotateSigningKeys(ctx context.Context) error {
+	rotated, err := models.RotateSigningKeys(false)
+	if rotated {
+		fmt.Println("Created a new JWT signing key")
+	}
+	return err
+}
+
+// extractResourceMetadata extracts the metadata of new resources. The
+// crawler adds resources straight to the database, so this task is what tags
+// them; metadata filters miss a resource until it has run.
+func extractResourceMetadata(ctx context.Context) error {
+	extracted, err := models.ExtractPendingResourceMetadata(false)
+	if extracted > 0 {
+		fmt.Printf("Extracted the metadata of %d resource(s)\n", extracted)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"testing"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminRoutesRequireAdminRole(t *testing.T) {
+	// Test users sign up as teachers
+	token := createTestUser(t)
+
+	w := makeTestRequest(t, "GET", "/v1/admin/users", "", token)
+	assert.Equal(t, 403, w.Code)
+
+	w = makeTestRequest(t, "GET", "/v1/admin/users", "", "")
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestLastAdminCannotBeDemoted(t *testing.T) {
+	claims, err := utils.ValidateJWT(createTestUser(t))
+	assert.NoError(t, err)
+	adminID := claims.UserID
+
+	// Make the test user the only admin
+	_, err = models.SetUserRole(adminID, models.RoleAdmin)
+	assert.NoError(t, err)
+	_, err = orm.NewOrm().Raw(`UPDATE users SET role = ? WHERE role = ? AND id <> ?`,
+		models.RoleTeacher, models.RoleAdmin, adminID).Exec()
+	assert.NoError(t, err)
+
+	_, err = models.SetUserRole(adminID, models.RoleTeacher)
+	assert.ErrorIs(t, err, models.ErrLastAdmin)
+
+	// With another admin around the demotion goes through
+	other := &models.User{Username: "otheradmin", Password: "unused", Email: "otheradmin@example.com", Role: models.RoleAdmin}
+	assert.NoError(t, models.CreateUser(other))
+	user, err := models.SetUserRole(adminID, models.RoleTeacher)
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleTeacher, user.Role)
+}
@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	key := &models.APIKey{Scopes: "resources:write,jobs:read"}
+
+	assert.Equal(t, []models.Permission{models.PermResourcesWrite, models.PermJobsRead}, key.Permissions())
+	assert.True(t, key.Can(models.PermResourcesWrite))
+	assert.True(t, key.Can(models.PermJobsRead))
+	assert.False(t, key.Can(models.PermJobsWrite))
+	assert.False(t, key.Can(models.PermUsersManage))
+
+	assert.Empty(t, (&models.APIKey{}).Permissions())
+}
+
+func TestParsePermission(t *testing.T) {
+	permission, err := models.ParsePermission(" Jobs:Read ")
+	assert.NoError(t, err)
+	assert.Equal(t, models.PermJobsRead, permission)
+
+	_, err = models.ParsePermission("jobs:delete")
+	assert.Error(t, err)
+}
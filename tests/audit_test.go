@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"testing"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVSafe(t *testing.T) {
+	tests := map[string]string{
+		"":                  "",
+		"alice":             "alice",
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+1":                "'+1",
+		"-1":                "'-1",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"\tcmd":             "'\tcmd",
+		"\rcmd":             "'\rcmd",
+		"a=b":               "a=b",
+	}
+	for value, want := range tests {
+		assert.Equal(t, want, utils.CSVSafe(value), "value %q", value)
+	}
+}
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	requireTestDB(t)
+	assert.NoError(t, models.RecordAuditEvent(&models.AuditEvent{
+		Action: "test.append_only", TargetType: "test", TargetID: "append-only", Result: "success",
+	}))
+
+	o := orm.NewOrm()
+	_, err := o.Raw(`UPDATE audit_events SET result = 'failure' WHERE target_id = 'append-only'`).Exec()
+	assert.ErrorContains(t, err, "append-only")
+	_, err = o.Raw(`DELETE FROM audit_events WHERE target_id = 'append-only'`).Exec()
+	assert.ErrorContains(t, err, "append-only")
+}
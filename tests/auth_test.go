@@ -1,9 +1,16 @@
 package tests
 
 import (
+	"cbc-backend/auth"
+	"cbc-backend/config"
+	"cbc-backend/models"
 	"cbc-backend/utils"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	beego "github.com/beego/beego/v2/server/web"
+	"github.com/beego/beego/v2/server/web/context"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,3 +36,118 @@ func TestProtectedRoutes(t *testing.T) {
 	w = makeTestRequest(t, "POST", "/v1/jobs", "{}", "invalid.token")
 	assert.Equal(t, 401, w.Code)
 }
+
+// loginWithCookies signs the test user in and returns the session and CSRF
+// cookies a browser would hold
+func loginWithCookies(t *testing.T) (session, csrf *http.Cookie) {
+	createTestUser(t)
+	w := makeTestRequest(t, "POST", "/v1/user/login", `{"username": "testuserN", "password": "password12N3"}`, "")
+	assert.Equal(t, 200, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		switch cookie.Name {
+		case config.SessionCookieName:
+			session = cookie
+		case config.CSRFCookieName:
+			csrf = cookie
+		}
+	}
+	return session, csrf
+}
+
+// makeCookieRequest makes a request carrying the session cookies and, if
+// set, the CSRF header
+func makeCookieRequest(method, path, csrfHeader string, cookies ...*http.Cookie) int {
+	r := httptest.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	if csrfHeader != "" {
+		r.Header.Set(auth.CSRFHeader, csrfHeader)
+	}
+	w := httptest.NewRecorder()
+	beego.BeeApp.Handlers.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestSessionRequiresCSRFToken(t *testing.T) {
+	session, csrf := loginWithCookies(t)
+	if !assert.NotNil(t, session) || !assert.NotNil(t, csrf) {
+		return
+	}
+
+	// The cookies alone are sent by any site, so they aren't enough
+	assert.Equal(t, 403, makeCookieRequest("POST", "/v1/user/logout", "", session, csrf))
+	assert.Equal(t, 403, makeCookieRequest("POST", "/v1/user/logout", "not-the-csrf-token", session, csrf))
+	assert.Equal(t, 200, makeCookieRequest("POST", "/v1/user/logout", csrf.Value, session, csrf))
+}
+
+func TestLogoutRejectsGet(t *testing.T) {
+	session, csrf := loginWithCookies(t)
+	if !assert.NotNil(t, session) || !assert.NotNil(t, csrf) {
+		return
+	}
+
+	// A GET skips the CSRF check, so any page could send one with an image
+	assert.NotEqual(t, 200, makeCookieRequest("GET", "/v1/user/logout", "", session, csrf))
+	assert.Equal(t, 200, makeCookieRequest("GET", "/v1/user/me", "", session, csrf))
+}
+
+func newAuthContext(header, value string) *context.Context {
+	ctx := context.NewContext()
+	req := httptest.NewRequest("GET", "/v1/user/me", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	ctx.Reset(httptest.NewRecorder(), req)
+	return ctx
+}
+
+func TestAuthenticateRejectsBadCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		message string
+	}{
+		{"missing", "", "", "Authorization header is required"},
+		{"not bearer", "Authorization", "Basic dXNlcjpwYXNz", "Invalid authorization format. Use: Bearer <token>"},
+		{"invalid token", "Authorization", "Bearer invalid.token.here", "Invalid or expired token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := auth.Authenticate(newAuthContext(tt.header, tt.value))
+			assert.Nil(t, principal)
+			var authErr *auth.Error
+			if assert.ErrorAs(t, err, &authErr) {
+				assert.Equal(t, 401, authErr.Status)
+				assert.Equal(t, tt.message, authErr.Message)
+			}
+		})
+	}
+}
+
+func TestPrincipalInContext(t *testing.T) {
+	ctx := newAuthContext("", "")
+	_, ok := auth.FromContext(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, "", auth.UserID(ctx))
+
+	auth.SetPrincipal(ctx, &auth.Principal{ID: "user-1", Role: models.RoleAdmin, Method: auth.MethodJWT})
+	principal, ok := auth.FromContext(ctx)
+	assert.True(t, ok)
+	assert.True(t, principal.IsAdmin())
+	assert.Equal(t, "user-1", auth.UserID(ctx))
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	teacher := &auth.Principal{Role: models.RoleTeacher, Scopes: models.RoleTeacher.Permissions(), Method: auth.MethodSession}
+	assert.True(t, teacher.Can(models.PermResourcesWrite))
+	assert.False(t, teacher.Can(models.PermUsersManage))
+
+	// API keys created by an admin only get their scopes
+	key := &models.APIKey{Scopes: string(models.PermJobsRead)}
+	service := &auth.Principal{Role: models.RoleAdmin, Scopes: key.Permissions(), Method: auth.MethodAPIKey, APIKey: key}
+	assert.True(t, service.Can(models.PermJobsRead))
+	assert.False(t, service.Can(models.PermJobsWrite))
+	assert.False(t, service.IsAdmin())
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/middleware"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func corsRequest(method, origin string, preflight bool) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/v1/user/me", nil)
+	req.Header.Set("Origin", origin)
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", "GET")
+	}
+	ctx := context.NewContext()
+	ctx.Reset(w, req)
+	middleware.CORS(ctx)
+	return w
+}
+
+func TestCORSAllowsOnlyConfiguredOrigins(t *testing.T) {
+	config.CORSAllowedOrigins = []string{"https://app.cbcexams.co.ke"}
+
+	w := corsRequest("GET", "https://app.cbcexams.co.ke", false)
+	assert.Equal(t, "https://app.cbcexams.co.ke", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Other sites can't read responses made with the session cookie
+	w = corsRequest("GET", "https://evil.example", false)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Preflights are answered without reaching the router
+	w = corsRequest("OPTIONS", "https://app.cbcexams.co.ke", true)
+	assert.Equal(t, 204, w.Code)
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "X-CSRF-Token")
+
+	w = corsRequest("OPTIONS", "https://evil.example", true)
+	assert.Equal(t, 204, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+}
@@ -8,14 +8,12 @@ import (
 )
 
 func TestDatabaseConnection(t *testing.T) {
-	err := models.InitDB(testDBConn)
-	assert.NoError(t, err)
-
-	err = models.TestDatabaseConnection()
-	assert.NoError(t, err)
+	requireTestDB(t)
+	assert.NoError(t, models.TestDatabaseConnection())
 }
 
 func TestEnsureTables(t *testing.T) {
+	requireTestDB(t)
 	assert.NoError(t, models.EnsureUsersTable())
 	assert.NoError(t, models.EnsureJobsTable())
 	assert.NoError(t, models.EnsureUploadsTable())
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"cbc-backend/controllers"
+	"cbc-backend/models"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceViewJSON(t *testing.T) {
+	view := controllers.DeviceView{
+		Device: models.Device{
+			ID:         "family-1",
+			UserID:     "user-1",
+			UserAgent:  "Mozilla/5.0",
+			IP:         "203.0.113.7",
+			CreatedAt:  time.Now(),
+			LastSeenAt: time.Now(),
+		},
+		Current: true,
+	}
+
+	content, err := json.Marshal(view)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "family-1", decoded["id"])
+	assert.Equal(t, "203.0.113.7", decoded["ip"])
+	assert.Equal(t, true, decoded["current"])
+	assert.NotContains(t, decoded, "user_id")
+}
+
+func TestSessionsRequireAuthentication(t *testing.T) {
+	w := makeTestRequest(t, "GET", "/v1/user/me/sessions", "", "")
+	assert.Equal(t, 401, w.Code)
+
+	w = makeTestRequest(t, "DELETE", "/v1/user/me/sessions/family-1", "", "invalid.token")
+	assert.Equal(t, 401, w.Code)
+}
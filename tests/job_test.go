@@ -1,10 +1,14 @@
 package tests
 
 import (
+	"cbc-backend/models"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/beego/beego/v2/client/orm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -95,3 +99,59 @@ func TestSearchJobs(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, response, "items")
 }
+
+func TestDeletedJobHiddenUntilRestored(t *testing.T) {
+	requireTestDB(t)
+
+	title := fmt.Sprintf("Trash Test Job %d", time.Now().UnixNano())
+	id, err := models.AddJob(models.Job{Title: title, Description: "Test Description", Type: "Full-time"})
+	assert.NoError(t, err)
+	jobID := int(id)
+
+	assert.NoError(t, models.DeleteJob(jobID))
+
+	// Deleted jobs are left out of lookups and searches
+	assert.ErrorIs(t, models.GetJob(&models.Job{Id: jobID}), orm.ErrNoRows)
+	results, err := models.SearchJobs(map[string]string{"title": title}, 1)
+	assert.NoError(t, err)
+	assert.Zero(t, results.TotalItems)
+
+	// They show up in the trash instead
+	items, _, err := models.ListTrash(models.TrashJob, 1, 100)
+	assert.NoError(t, err)
+	found := false
+	for _, item := range items {
+		found = found || item.ID == strconv.Itoa(jobID)
+	}
+	assert.True(t, found, "deleted job should be in the trash")
+
+	assert.NoError(t, models.RestoreFromTrash(models.TrashJob, strconv.Itoa(jobID)))
+	assert.NoError(t, models.GetJob(&models.Job{Id: jobID}))
+	results, err = models.SearchJobs(map[string]string{"title": title}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), results.TotalItems)
+
+	// Only items in the trash can be restored
+	assert.ErrorIs(t, models.RestoreFromTrash(models.TrashJob, strconv.Itoa(jobID)), orm.ErrNoRows)
+}
+
+func TestOnlyPosterCanChangeJob(t *testing.T) {
+	token := createTestUser(t)
+	userID := verifyTestUser(t, token)
+
+	id, err := models.AddJob(models.Job{Title: "Someone Else's Job", Type: "Full-time", PostedBy: "another-user"})
+	assert.NoError(t, err)
+	path := fmt.Sprintf("/v1/jobs/%d", id)
+
+	w := makeTestRequest(t, "PUT", path, `{"title": "Taken Over"}`, token)
+	assert.Equal(t, 403, w.Code)
+	w = makeTestRequest(t, "DELETE", path, "", token)
+	assert.Equal(t, 403, w.Code)
+	assert.NoError(t, models.GetJob(&models.Job{Id: int(id)}))
+
+	// The poster can change their own job
+	id, err = models.AddJob(models.Job{Title: "My Job", Type: "Full-time", PostedBy: userID})
+	assert.NoError(t, err)
+	w = makeTestRequest(t, "DELETE", fmt.Sprintf("/v1/jobs/%d", id), "", token)
+	assert.Equal(t, 200, w.Code)
+}
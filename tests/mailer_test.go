@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"cbc-backend/mailer"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureMailer records sent messages and fails the first `failures` sends
+type captureMailer struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     []mailer.Message
+}
+
+func (m *captureMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+	if m.attempts <= m.failures {
+		return errors.New("smtp unavailable")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestRenderPasswordReset(t *testing.T) {
+	msg, err := mailer.Render("password_reset", map[string]interface{}{
+		"Username":  "teacher1",
+		"ResetURL":  "http://localhost:3000/reset-password?token=abc",
+		"ExpiresIn": "24 hours",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Reset your CBC Exams password", msg.Subject)
+	assert.Contains(t, msg.Text, "http://localhost:3000/reset-password?token=abc")
+	assert.Contains(t, msg.HTML, `href="http://localhost:3000/reset-password?token=abc"`)
+
+	_, err = mailer.Render("does_not_exist", nil)
+	assert.Error(t, err)
+}
+
+func TestRenderErasureScheduled(t *testing.T) {
+	msg, err := mailer.Render("erasure_scheduled", map[string]interface{}{
+		"Username":   "teacher1",
+		"ErasureAt":  "1 March 2026 10:00 UTC",
+		"AccountURL": "http://localhost:3000/account",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Your CBC Exams account will be deleted", msg.Subject)
+	assert.Contains(t, msg.Text, "1 March 2026 10:00 UTC")
+	assert.Contains(t, msg.HTML, `href="http://localhost:3000/account"`)
+}
+
+func TestRenderOrganizationInvitation(t *testing.T) {
+	msg, err := mailer.Render("organization_invitation", map[string]interface{}{
+		"OrganizationName": "Moi Primary School",
+		"InvitedBy":        "headteacher",
+		"Role":             "teacher",
+		"InvitationURL":    "http://localhost:3000/invitations?token=abc",
+		"ExpiresIn":        "7 days",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Join Moi Primary School on CBC Exams", msg.Subject)
+	assert.Contains(t, msg.Text, "headteacher has invited you")
+	assert.Contains(t, msg.HTML, `href="http://localhost:3000/invitations?token=abc"`)
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &mailer.OutboxMailer{Dir: dir}
+
+	err := m.Send(mailer.Message{To: "teacher@example.com", Subject: "Hello", Text: "text", HTML: "<p>html</p>"})
+	assert.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	content, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "To: teacher@example.com")
+	assert.Contains(t, string(content), "Subject: Hello")
+}
+
+func TestQueueRetriesFailedDelivery(t *testing.T) {
+	m := &captureMailer{failures: 1}
+	queue := mailer.NewQueue(m, 10, 3)
+	queue.Start(1)
+
+	assert.NoError(t, queue.Enqueue(mailer.Message{To: "teacher@example.com", Subject: "Retry"}))
+	queue.Close()
+
+	assert.Equal(t, 2, m.attempts)
+	assert.Len(t, m.sent, 1)
+}
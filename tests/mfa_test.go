@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/utils"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFACodesThrottledAcrossLogins(t *testing.T) {
+	token := createTestUser(t)
+
+	// Turn on two-factor authentication
+	w := makeTestRequest(t, "POST", "/v1/user/mfa/enroll", "", token)
+	assert.Equal(t, 200, w.Code)
+	var enrollment struct {
+		Data struct {
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	counter := utils.TOTPCounter(time.Now())
+	code, err := utils.TOTPCode(enrollment.Data.Secret, counter)
+	assert.NoError(t, err)
+	w = makeTestRequest(t, "POST", "/v1/user/mfa/confirm", fmt.Sprintf(`{"code": %q}`, code), token)
+	assert.Equal(t, 200, w.Code)
+
+	// A code from far in the future is never valid now
+	wrongCode, err := utils.TOTPCode(enrollment.Data.Secret, counter+1000)
+	assert.NoError(t, err)
+
+	// Signing in with the password again must not reset the count of wrong
+	// codes, or codes could be guessed without limit
+	throttled := false
+	for i := 0; i <= config.LoginDelayThreshold && !throttled; i++ {
+		w = makeTestRequest(t, "POST", "/v1/user/login", `{"username": "testuserN", "password": "password12N3"}`, "")
+		if w.Code == 429 {
+			throttled = true
+			break
+		}
+		assert.Equal(t, 200, w.Code)
+		var login struct {
+			Data struct {
+				MFAToken string `json:"mfa_token"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+		assert.NotEmpty(t, login.Data.MFAToken)
+
+		w = makeTestRequest(t, "POST", "/v1/user/login/mfa",
+			fmt.Sprintf(`{"mfa_token": %q, "code": %q}`, login.Data.MFAToken, wrongCode), "")
+		throttled = w.Code == 429
+		if !throttled {
+			assert.Equal(t, 401, w.Code)
+		}
+	}
+	assert.True(t, throttled, "wrong codes across fresh logins should be throttled")
+}
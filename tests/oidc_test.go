@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"cbc-backend/oidc"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	beego "github.com/beego/beego/v2/server/web"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIssuer is a minimal OpenID Connect provider. authorize stands in for
+// the user approving the login at the provider and returns a code.
+type mockIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key, clientID: "cbc-client", codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		grant, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+
+		if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, grant.claims, m.key),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize approves the login described by the authorization URL and
+// returns the code the provider would redirect back with
+func (m *mockIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+
+	defaults := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   m.clientID,
+		"sub":   "google-user-1",
+		"email": "teacher@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		defaults[k] = v
+	}
+
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: defaults}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIssuer) provider() *oidc.Provider {
+	return oidc.NewProvider(m.server.URL, m.clientID, "secret", "http://localhost:3000/oidc/callback",
+		[]string{"openid", "email", "profile"})
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	assert.NoError(t, err)
+
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	assert.Equal(t, issuer.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "cbc-client", q.Get("client_id"))
+	assert.Equal(t, "openid email profile", q.Get("scope"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, oidc.CodeChallenge("verifier-1"), q.Get("code_challenge"))
+
+	code := issuer.authorize(t, authURL, jwt.MapClaims{"email_verified": true, "name": "Jane Teacher"})
+	idToken, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1")
+	assert.NoError(t, err)
+	assert.Equal(t, issuer.server.URL, idToken.Issuer)
+	assert.Equal(t, "google-user-1", idToken.Subject)
+	assert.Equal(t, "teacher@example.com", idToken.Email)
+	assert.True(t, idToken.EmailVerified)
+	assert.Equal(t, "Jane Teacher", idToken.Name)
+}
+
+func TestOIDCRejectsInvalidLogins(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-2", "nonce-2", "verifier-2")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		verifier string
+		nonce    string
+	}{
+		{"wrong code verifier", nil, "other-verifier", "nonce-2"},
+		{"wrong nonce", nil, "verifier-2", "other-nonce"},
+		{"other audience", jwt.MapClaims{"aud": "other-client"}, "verifier-2", "nonce-2"},
+		{"other issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, "verifier-2", "nonce-2"},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, "verifier-2", "nonce-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := issuer.authorize(t, authURL, tt.claims)
+			_, err := provider.Exchange(ctx, code, tt.verifier, tt.nonce)
+			assert.Error(t, err)
+		})
+	}
+
+	// Audience may also be a list
+	code := issuer.authorize(t, authURL, jwt.MapClaims{"aud": []string{"other-client", "cbc-client"}})
+	_, err = provider.Exchange(ctx, code, "verifier-2", "nonce-2")
+	assert.NoError(t, err)
+}
+
+func TestOIDCRejectsForgedSignature(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	forged := issuer.sign(t, jwt.MapClaims{
+		"iss":   issuer.server.URL,
+		"aud":   issuer.clientID,
+		"sub":   "google-user-1",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce-3",
+	}, otherKey)
+
+	_, err = provider.VerifyIDToken(context.Background(), forged, "nonce-3")
+	assert.Error(t, err)
+}
+
+func TestOIDCCallbackRequiresStartingBrowser(t *testing.T) {
+	requireTestDB(t)
+
+	issuer := newMockIssuer(t)
+	oidc.Default = issuer.provider()
+	defer func() { oidc.Default = nil }()
+
+	// The attacker starts a login and stops before the callback
+	w := makeTestRequest(t, "GET", "/v1/user/oidc/login", "", "")
+	assert.Equal(t, 200, w.Code)
+	var response struct {
+		Data struct {
+			AuthorizationURL string `json:"authorization_url"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	var stateCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "cbc_oidc_state" {
+			stateCookie = cookie
+		}
+	}
+	if assert.NotNil(t, stateCookie) {
+		assert.True(t, stateCookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+	}
+
+	u, _ := url.Parse(response.Data.AuthorizationURL)
+	state := u.Query().Get("state")
+	code := issuer.authorize(t, response.Data.AuthorizationURL, jwt.MapClaims{"email_verified": true})
+	callback := "/v1/user/oidc/callback?code=" + url.QueryEscape(code) + "&state=" + url.QueryEscape(state)
+
+	// A victim opening the callback URL has no state cookie
+	w = makeTestRequest(t, "GET", callback, "", "")
+	assert.Equal(t, 400, w.Code)
+
+	// The browser that started the login finishes it
+	r := httptest.NewRequest("GET", callback, nil)
+	r.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	beego.BeeApp.Handlers.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+}
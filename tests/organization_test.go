@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"cbc-backend/utils"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgRoleAtLeast(t *testing.T) {
+	assert.True(t, models.OrgOwner.AtLeast(models.OrgAdmin))
+	assert.True(t, models.OrgAdmin.AtLeast(models.OrgAdmin))
+	assert.True(t, models.OrgAdmin.AtLeast(models.OrgTeacher))
+	assert.False(t, models.OrgTeacher.AtLeast(models.OrgAdmin))
+	assert.False(t, models.OrgAdmin.AtLeast(models.OrgOwner))
+	assert.False(t, models.OrgRole("principal").AtLeast(models.OrgTeacher))
+}
+
+func TestParseOrgRole(t *testing.T) {
+	role, err := models.ParseOrgRole(" Admin ")
+	assert.NoError(t, err)
+	assert.Equal(t, models.OrgAdmin, role)
+
+	_, err = models.ParseOrgRole("student")
+	assert.Error(t, err)
+}
+
+// newOrgTestUser creates a teacher with a unique username and email
+func newOrgTestUser(t *testing.T) *models.User {
+	name := fmt.Sprintf("member%d", time.Now().UnixNano())
+	user := &models.User{Username: name, Password: "unused", Email: name + "@example.com", Role: models.RoleTeacher}
+	require.NoError(t, models.CreateUser(user))
+	return user
+}
+
+// addTestMember creates a user and adds them to the organization with the role
+func addTestMember(t *testing.T, orgID string, role models.OrgRole) *models.User {
+	user := newOrgTestUser(t)
+	addExistingMember(t, orgID, user, role)
+	return user
+}
+
+// addExistingMember adds an existing user to the organization with the role
+func addExistingMember(t *testing.T, orgID string, user *models.User, role models.OrgRole) {
+	_, token, err := models.InviteToOrganization(orgID, user.Email, role, "", time.Hour)
+	require.NoError(t, err)
+	_, err = models.AcceptInvitation(token, user)
+	require.NoError(t, err)
+}
+
+func TestOrgAdminsCantChangePeers(t *testing.T) {
+	token := createTestUser(t)
+	claims, err := utils.ValidateJWT(token)
+	require.NoError(t, err)
+	admin, err := models.GetUserByID(claims.UserID)
+	require.NoError(t, err)
+
+	org, err := models.CreateOrganization("Test School", newOrgTestUser(t).ID)
+	require.NoError(t, err)
+	addExistingMember(t, org.ID, admin, models.OrgAdmin)
+
+	peer := addTestMember(t, org.ID, models.OrgAdmin)
+	teacher := addTestMember(t, org.ID, models.OrgTeacher)
+	memberPath := func(user *models.User) string {
+		return fmt.Sprintf("/v1/organizations/%s/members/%s", org.ID, user.ID)
+	}
+
+	w := makeTestRequest(t, "PUT", memberPath(peer), `{"role": "teacher"}`, token)
+	assert.Equal(t, 403, w.Code)
+	w = makeTestRequest(t, "PUT", memberPath(teacher), `{"role": "owner"}`, token)
+	assert.Equal(t, 403, w.Code)
+	member, err := models.GetMembership(org.ID, peer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrgAdmin, member.Role)
+
+	// Teachers can still be promoted up to the admin's own role
+	w = makeTestRequest(t, "PUT", memberPath(teacher), `{"role": "admin"}`, token)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestErasingOnlyOwnerHandsOverOrganization(t *testing.T) {
+	requireTestDB(t)
+	owner := newOrgTestUser(t)
+	org, err := models.CreateOrganization("Handover School", owner.ID)
+	require.NoError(t, err)
+	addTestMember(t, org.ID, models.OrgTeacher)
+	firstAdmin := addTestMember(t, org.ID, models.OrgAdmin)
+	laterAdmin := addTestMember(t, org.ID, models.OrgAdmin)
+
+	require.NoError(t, models.EraseUser(owner.ID))
+
+	// The longest-standing admin takes over
+	member, err := models.GetMembership(org.ID, firstAdmin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrgOwner, member.Role)
+	member, err = models.GetMembership(org.ID, laterAdmin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrgAdmin, member.Role)
+}
+
+func TestErasingOnlyOwnerWithoutAdminRefused(t *testing.T) {
+	requireTestDB(t)
+	owner := newOrgTestUser(t)
+	org, err := models.CreateOrganization("Ownerless School", owner.ID)
+	require.NoError(t, err)
+	addTestMember(t, org.ID, models.OrgTeacher)
+
+	_, err = models.ScheduleUserErasure(owner.ID, time.Now())
+	assert.ErrorIs(t, err, models.ErrNoOrgSuccessor)
+	assert.ErrorIs(t, models.EraseUser(owner.ID), models.ErrNoOrgSuccessor)
+
+	// Nothing was erased
+	_, err = models.GetUserByID(owner.ID)
+	assert.NoError(t, err)
+	member, err := models.GetMembership(org.ID, owner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrgOwner, member.Role)
+}
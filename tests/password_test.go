@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func violationCodes(err error) []string {
+	policyErr, ok := err.(*utils.PasswordPolicyError)
+	if !ok {
+		return nil
+	}
+	codes := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	config.PasswordMinLength = 10
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{"valid", "correct-horse-battery", nil},
+		{"empty", "", []string{"too_short"}},
+		{"short", "Sh0rt!", []string{"too_short"}},
+		{"too long", strings.Repeat("a", 73), []string{"too_long"}},
+		{"common", "Password123", []string{"too_common"}},
+		{"contains username", "my-wanjiku-secret", []string{"contains_username"}},
+		{"contains email", "j.otieno-rocks-2024", []string{"contains_email"}},
+		{"multiple", "wanjiku", []string{"too_short", "contains_username"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := utils.CheckPasswordPolicy(tt.password, "Wanjiku", "j.otieno@example.com")
+			if tt.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.expected, violationCodes(err))
+		})
+	}
+}
+
+func TestSignupRejectsWeakPassword(t *testing.T) {
+	requireTestDB(t)
+
+	body := `{
+		"username": "weakuser",
+		"password": "password",
+		"email": "weak@example.com"
+	}`
+
+	w := makeTestRequest(t, "POST", "/v1/user/signup", body, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "too_common")
+}
+
+func TestGenerateRandomString(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		s := utils.GenerateRandomString(32)
+		assert.Len(t, s, 32)
+		assert.Regexp(t, "^[A-Za-z0-9]+$", s)
+		assert.False(t, seen[s], "duplicate random string")
+		seen[s] = true
+	}
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"cbc-backend/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePersonalDataArchive(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "u1.pdf")
+	require.NoError(t, os.WriteFile(filePath, []byte("exam paper"), 0644))
+
+	data := &models.PersonalData{
+		Account: map[string]interface{}{"id": "user-1", "username": "teacher1"},
+		Uploads: []models.Upload{
+			{Id: "u1", FileName: "../../Grade 4 Maths.pdf", FilePath: filePath, UserID: "user-1"},
+			{Id: "u2", FileName: "gone.pdf", FilePath: filepath.Join(dir, "gone.pdf"), UserID: "user-1"},
+		},
+		Jobs:           []models.Job{{Id: 7, Title: "Maths teacher", PostedBy: "user-1"}},
+		PasswordResets: []models.PasswordResetRecord{{Used: true}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, models.WritePersonalDataArchive(&buf, "user-1", data))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		files[f.Name] = content
+	}
+
+	// Upload names can't escape their directory
+	assert.Equal(t, "exam paper", string(files["uploads/u1/Grade 4 Maths.pdf"]))
+
+	var manifest models.ExportManifest
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	assert.Equal(t, "user-1", manifest.UserID)
+	assert.Equal(t, []string{"u2"}, manifest.MissingFiles)
+	assert.Len(t, manifest.Files, len(files)-1)
+	for _, f := range manifest.Files {
+		content, ok := files[f.Path]
+		if assert.True(t, ok, f.Path) {
+			sum := sha256.Sum256(content)
+			assert.Equal(t, hex.EncodeToString(sum[:]), f.SHA256, f.Path)
+			assert.Equal(t, int64(len(content)), f.Size, f.Path)
+		}
+	}
+
+	var jobs []models.Job
+	require.NoError(t, json.Unmarshal(files["jobs.json"], &jobs))
+	assert.Equal(t, "Maths teacher", jobs[0].Title)
+	assert.NotContains(t, string(files["password_resets.json"]), "token")
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestProfileUpdate(t *testing.T) {
+	profile := &models.UserProfile{UserID: "teacher-1"}
+	subjects := []string{"Mathematics", " Physics ", "mathematics", ""}
+	update := &models.ProfileUpdate{
+		DisplayName: strPtr("  Jane Wanjiru "),
+		Phone:       strPtr("+254 712 345678"),
+		Subjects:    &subjects,
+		TSCNumber:   strPtr("123456"),
+	}
+
+	assert.NoError(t, update.Apply(profile, models.RoleTeacher))
+	assert.Equal(t, "Jane Wanjiru", profile.DisplayName)
+	assert.Equal(t, "+254 712 345678", profile.Phone)
+	assert.Equal(t, []string{"Mathematics", "Physics"}, profile.SubjectList())
+	assert.Equal(t, "123456", profile.TSCNumber)
+	assert.Equal(t, []string{}, profile.GradeLevelList())
+}
+
+func TestProfileUpdateRejectsFields(t *testing.T) {
+	profile := &models.UserProfile{UserID: "student-1", DisplayName: "Before"}
+
+	// Students can't claim a TSC number or teaching subjects
+	subjects := []string{"Chemistry"}
+	update := &models.ProfileUpdate{
+		DisplayName: strPtr("After"),
+		Subjects:    &subjects,
+		TSCNumber:   strPtr("123456"),
+	}
+	err := update.Apply(profile, models.RoleStudent)
+	fieldErr, ok := err.(*models.ProfileFieldError)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"subjects", "tsc_number"}, fieldErr.Forbidden)
+	assert.Equal(t, "Before", profile.DisplayName, "nothing changes when a field is rejected")
+
+	update = &models.ProfileUpdate{Phone: strPtr("call me"), TSCNumber: strPtr("TSC-1")}
+	err = update.Apply(profile, models.RoleTeacher)
+	fieldErr, ok = err.(*models.ProfileFieldError)
+	assert.True(t, ok)
+	assert.Contains(t, fieldErr.Invalid, "phone")
+	assert.Contains(t, fieldErr.Invalid, "tsc_number")
+}
+
+func TestProfileView(t *testing.T) {
+	user := &models.User{ID: "teacher-1", Username: "jane", Email: "jane@example.com", Role: models.RoleTeacher}
+	profile := &models.UserProfile{UserID: user.ID, DisplayName: "Jane", Phone: "0712345678", TSCNumber: "123456"}
+
+	own := models.ProfileView(user, profile, user.ID, models.RoleTeacher)
+	assert.Equal(t, "jane@example.com", own["email"])
+	assert.Equal(t, "0712345678", own["phone"])
+	assert.Equal(t, "123456", own["tsc_number"])
+
+	admin := models.ProfileView(user, profile, "admin-1", models.RoleAdmin)
+	assert.Equal(t, "0712345678", admin["phone"])
+
+	other := models.ProfileView(user, profile, "student-1", models.RoleStudent)
+	assert.Equal(t, "Jane", other["display_name"])
+	assert.NotContains(t, other, "email")
+	assert.NotContains(t, other, "phone")
+	assert.NotContains(t, other, "tsc_number")
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// refreshTestToken exchanges a refresh token and returns the response code
+// together with the new refresh token, if any
+func refreshTestToken(t *testing.T, refreshToken string) (int, string) {
+	w := makeTestRequest(t, "POST", "/v1/user/refresh", fmt.Sprintf(`{"refresh_token": %q}`, refreshToken), "")
+	var response struct {
+		Data struct {
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response.Data.RefreshToken
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	createTestUser(t)
+	w := makeTestRequest(t, "POST", "/v1/user/login", `{"username": "testuserN", "password": "password12N3"}`, "")
+	assert.Equal(t, 200, w.Code)
+	var login struct {
+		Data struct {
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	first := login.Data.RefreshToken
+	assert.NotEmpty(t, first)
+
+	// Each refresh hands out a new refresh token
+	code, second := refreshTestToken(t, first)
+	assert.Equal(t, 200, code)
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+
+	// Using a rotated token again means it was stolen, so the whole family
+	// is revoked, including the token that replaced it
+	code, _ = refreshTestToken(t, first)
+	assert.Equal(t, 401, code)
+	code, _ = refreshTestToken(t, second)
+	assert.Equal(t, 401, code)
+}
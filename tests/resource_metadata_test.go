@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"cbc-backend/models"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractResourceMetadata(t *testing.T) {
+	type metadata struct {
+		grade, subject, examType string
+		term, year               int
+		markingScheme            bool
+	}
+	tests := []struct {
+		resource models.Resource
+		want     metadata
+	}{
+		{
+			models.Resource{Name: "Grade 7 Maths Term 2 End Term Exam 2024.pdf"},
+			metadata{grade: "Grade 7", subject: "Mathematics", examType: "End Term", term: 2, year: 2024},
+		},
+		{
+			models.Resource{Name: "MARKING SCHEME.pdf", ParentDirectory: "GRADE_6/KPSEA_2023/Integrated-Science"},
+			metadata{grade: "Grade 6", subject: "Integrated Science", examType: "KPSEA", year: 2023, markingScheme: true},
+		},
+		{
+			models.Resource{Name: "pp2 language activities opener term one.docx"},
+			metadata{grade: "PP2", examType: "Opener", term: 1},
+		},
+		{
+			models.Resource{Name: "Form 4 Chem Paper 1 KCSE mock.pdf"},
+			metadata{grade: "Form 4", subject: "Chemistry", examType: "KCSE"},
+		},
+		{
+			models.Resource{Name: "CRE & Kiswahili.pdf", RelativePath: "Grade Eight/Mid-Term/CRE & Kiswahili.pdf"},
+			metadata{grade: "Grade 8", subject: "CRE", examType: "Mid Term"},
+		},
+		{
+			// The name wins over the folder
+			models.Resource{Name: "G9 English.pdf", ParentDirectory: "Grade 8 Kiswahili", Categories: "{Term 3}"},
+			metadata{grade: "Grade 9", subject: "English", term: 3},
+		},
+		{
+			models.Resource{Name: "Schemes of work 9999.pdf"},
+			metadata{},
+		},
+	}
+	for _, tt := range tests {
+		m := models.ExtractResourceMetadata(&tt.resource)
+		got := metadata{markingScheme: m.MarkingScheme}
+		if m.Grade != nil {
+			got.grade = *m.Grade
+		}
+		if m.Subject != nil {
+			got.subject = *m.Subject
+		}
+		if m.ExamType != nil {
+			got.examType = *m.ExamType
+		}
+		if m.Term != nil {
+			got.term = *m.Term
+		}
+		if m.Year != nil {
+			got.year = *m.Year
+		}
+		assert.Equal(t, tt.want, got, tt.resource.Name)
+		assert.Equal(t, models.ResourceMetadataVersion, m.ExtractorVersion)
+	}
+}
+
+func TestNormalizeResourceMetadataFilters(t *testing.T) {
+	assert.Equal(t, "Grade 7", models.NormalizeGrade("g7"))
+	assert.Equal(t, "Grade 10", models.NormalizeGrade("Grade 10"))
+	assert.Equal(t, "PP1", models.NormalizeGrade("PP 1"))
+	assert.Equal(t, "", models.NormalizeGrade("year 7"))
+	assert.Equal(t, "Mathematics", models.NormalizeSubject("maths"))
+	assert.Equal(t, "Social Studies", models.NormalizeSubject("SST"))
+	assert.Equal(t, "", models.NormalizeSubject("astrology"))
+	assert.Equal(t, "End Term", models.NormalizeExamType("end-of-term"))
+}
+
+func TestResourceMetadataFilters(t *testing.T) {
+	requireTestDB(t)
+
+	w := makeTestRequest(t, "GET", "/v1/resources?grade=g7&subject=maths&term=2&year=2024&exam_type=kpsea&marking_scheme=true", "", "")
+	assert.Equal(t, 200, w.Code)
+
+	for _, query := range []string{"grade=year+9", "subject=astrology", "term=4", "year=last", "marking_scheme=yes"} {
+		w := makeTestRequest(t, "GET", "/v1/resources?"+query, "", "")
+		assert.Equal(t, 400, w.Code, query)
+	}
+}
+
+func TestResourceMetadataJSON(t *testing.T) {
+	grade, year := "Grade 7", 2024
+	content, err := json.Marshal(models.ResourceMetadata{ResourceID: "6f1c", Grade: &grade, Year: &year})
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "Grade 7", decoded["grade"])
+	assert.Equal(t, float64(2024), decoded["year"])
+	assert.Nil(t, decoded["subject"])
+	assert.NotContains(t, decoded, "resource_id")
+}
@@ -2,11 +2,14 @@ package tests
 
 import (
 	"bytes"
+	"cbc-backend/models"
+	"cbc-backend/utils"
 	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
@@ -63,3 +66,128 @@ func TestResourceList(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, response, "items")
 }
+
+func TestResourceSearch(t *testing.T) {
+	requireTestDB(t)
+
+	// Words match in any order, with web search syntax
+	for _, query := range []string{"grade 7 maths term 2", "term 2 maths grade 7", `"marking scheme" -kcse`} {
+		w := makeTestRequest(t, "GET", "/v1/resources?name="+url.QueryEscape(query), "", "")
+		assert.Equal(t, 200, w.Code, query)
+	}
+}
+
+func TestResourceFacets(t *testing.T) {
+	requireTestDB(t)
+
+	for _, mode := range []string{"and", "or"} {
+		w := makeTestRequest(t, "GET", "/v1/resources?categories=Grade+7&categories=Mathematics&category_mode="+mode, "", "")
+		assert.Equal(t, 200, w.Code, mode)
+
+		var response struct {
+			Data struct {
+				TotalItems int64                 `json:"total_items"`
+				Facets     models.ResourceFacets `json:"facets"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.NotNil(t, response.Data.Facets.Categories)
+		assert.NotNil(t, response.Data.Facets.Directories)
+	}
+
+	w := makeTestRequest(t, "GET", "/v1/resources?categories=Grade+7&category_mode=xor", "", "")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestResourceSearchResultJSON(t *testing.T) {
+	result := models.ResourceSearchResult{
+		Resource:  models.Resource{Id: "6f1c", Name: "Grade 7 Maths Term 2.pdf"},
+		Rank:      0.6,
+		Highlight: "<mark>Grade</mark> <mark>7</mark> <mark>Maths</mark>",
+	}
+	content, err := json.Marshal(result)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "6f1c", decoded["id"])
+	assert.Equal(t, "Grade 7 Maths Term 2.pdf", decoded["name"])
+	assert.Contains(t, decoded["highlight"], "<mark>Maths</mark>")
+
+	// Listings without a query have no rank or snippet
+	content, err = json.Marshal(models.ResourceSearchResult{Resource: models.Resource{Id: "6f1c"}})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "highlight")
+}
+
+func TestResourceGetCategories(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"", []string{}},
+		{"{}", []string{}},
+		{"{", []string{}},
+		{"Grade 4", []string{}},
+		{"{Grade 4,Maths}", []string{"Grade 4", "Maths"}},
+		{`{"Maths, Term 1",Exams}`, []string{"Maths, Term 1", "Exams"}},
+		{`{"say \"hi\"",NULL,""}`, []string{`say "hi"`, ""}},
+	}
+	for _, tt := range tests {
+		r := models.Resource{Categories: tt.raw}
+		assert.Equal(t, tt.want, r.GetCategories(), tt.raw)
+	}
+}
+
+func TestResourceBreadcrumbs(t *testing.T) {
+	r := models.Resource{Name: "Term 1.pdf", RelativePath: "/Grade 4/Mathematics/Term 1.pdf"}
+	assert.Equal(t, []models.Breadcrumb{
+		{Name: "Grade 4", Path: "Grade 4"},
+		{Name: "Mathematics", Path: "Grade 4/Mathematics"},
+	}, r.Breadcrumbs())
+
+	// Paths naming only the folder keep every segment
+	r = models.Resource{Name: "Term 1.pdf", RelativePath: `Grade 4\Mathematics`}
+	assert.Len(t, r.Breadcrumbs(), 2)
+
+	r = models.Resource{Name: "a.pdf", RelativePath: "a.pdf"}
+	assert.Empty(t, r.Breadcrumbs())
+}
+
+func TestResourceDetailJSON(t *testing.T) {
+	detail := models.ResourceDetail{
+		Resource:      &models.Resource{Id: "6f1c", Name: "Term 1.pdf", Categories: "{Grade 4,Maths}", ParentUrl: "https://example.com/grade-4"},
+		Source:        "https://example.com/grade-4",
+		Categories:    []string{"Grade 4", "Maths"},
+		DownloadCount: 3,
+		Breadcrumbs:   []models.Breadcrumb{},
+	}
+	content, err := json.Marshal(detail)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "6f1c", decoded["id"])
+	assert.Equal(t, []interface{}{"Grade 4", "Maths"}, decoded["categories"])
+	assert.Equal(t, float64(3), decoded["download_count"])
+	assert.Equal(t, "https://example.com/grade-4", decoded["source"])
+}
+
+func TestUnverifiedUserCannotUpload(t *testing.T) {
+	token := createTestUser(t)
+
+	// New accounts start out unverified
+	w := makeTestRequest(t, "POST", "/v1/resources", "", token)
+	assert.Equal(t, 403, w.Code)
+
+	claims, err := utils.ValidateJWT(token)
+	assert.NoError(t, err)
+	verification, err := models.CreateEmailVerification(claims.UserID)
+	assert.NoError(t, err)
+	_, err = models.VerifyEmail(verification)
+	assert.NoError(t, err)
+
+	// Once verified, the request gets past the permission check
+	w = makeTestRequest(t, "POST", "/v1/resources", "", token)
+	assert.NotEqual(t, 403, w.Code)
+}
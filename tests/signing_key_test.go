@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigningKey(t *testing.T, alg string, activatesAt time.Time) *utils.SigningKey {
+	key, err := utils.GenerateSigningKey(alg, activatesAt)
+	require.NoError(t, err)
+	return key
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestSignAndVerifyJWT(t *testing.T) {
+	config.AccessTokenTTL = 15 * time.Minute
+	for _, alg := range []string{utils.AlgRS256, utils.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key := newTestSigningKey(t, alg, time.Now().Add(-time.Hour))
+			utils.SetSigningKeys([]*utils.SigningKey{key})
+
+			token, err := utils.GenerateJWT("user-1", "teacher1", "teacher", "family-1", false)
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, tokenKeyID(t, token))
+
+			claims, err := utils.ValidateJWT(token)
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", claims.UserID)
+
+			// A tampered payload fails verification
+			parts := strings.Split(token, ".")
+			parts[1] = jwt.EncodeSegment([]byte(`{"user_id":"admin-1","exp":9999999999}`))
+			_, err = utils.ValidateJWT(strings.Join(parts, "."))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	config.AccessTokenTTL = 15 * time.Minute
+	old := newTestSigningKey(t, utils.AlgRS256, time.Now().Add(-time.Hour))
+	next := newTestSigningKey(t, utils.AlgEdDSA, time.Now().Add(time.Minute))
+	utils.SetSigningKeys([]*utils.SigningKey{next, old})
+
+	// The next key is published but doesn't sign until it activates
+	token, err := utils.GenerateJWT("user-1", "teacher1", "teacher", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, tokenKeyID(t, token))
+	assert.Len(t, utils.PublicJWKS().Keys, 2)
+
+	next.ActivatesAt = time.Now().Add(-time.Second)
+	utils.SetSigningKeys([]*utils.SigningKey{old, next})
+	newToken, err := utils.GenerateJWT("user-1", "teacher1", "teacher", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, tokenKeyID(t, newToken))
+
+	// Tokens signed with the old key stay valid until it is retired
+	_, err = utils.ValidateJWT(token)
+	assert.NoError(t, err)
+	utils.SetSigningKeys([]*utils.SigningKey{next})
+	_, err = utils.ValidateJWT(token)
+	assert.Error(t, err)
+	_, err = utils.ValidateJWT(newToken)
+	assert.NoError(t, err)
+}
+
+func TestJWTRejectsAlgorithmMismatch(t *testing.T) {
+	key := newTestSigningKey(t, utils.AlgRS256, time.Now().Add(-time.Hour))
+	utils.SetSigningKeys([]*utils.SigningKey{key})
+	config.JWTSecret = nil
+
+	// An HS256 token can't claim to be signed with the RSA key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "admin-1"})
+	forged.Header["kid"] = key.ID
+	signed, err := forged.SignedString([]byte("not the private key"))
+	require.NoError(t, err)
+	_, err = utils.ValidateJWT(signed)
+	assert.Error(t, err)
+
+}
+
+func TestLegacyHS256Tokens(t *testing.T) {
+	config.AccessTokenTTL = 15 * time.Minute
+	key := newTestSigningKey(t, utils.AlgRS256, time.Now().Add(-5*time.Minute))
+	utils.SetSigningKeys([]*utils.SigningKey{key})
+	legacyToken := func(issuedAt time.Time) string {
+		claims := jwt.MapClaims{"user_id": "user-1", "iat": issuedAt.Unix()}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacy-secret"))
+		require.NoError(t, err)
+		return token
+	}
+
+	// Tokens without a kid need the legacy secret
+	config.JWTSecret = nil
+	_, err := utils.ValidateJWT(legacyToken(time.Now().Add(-10 * time.Minute)))
+	assert.Error(t, err)
+	config.JWTSecret = []byte("legacy-secret")
+	defer func() { config.JWTSecret = nil }()
+	_, err = utils.ValidateJWT(legacyToken(time.Now().Add(-10 * time.Minute)))
+	assert.NoError(t, err)
+
+	// The secret can't mint tokens after the first key, nor revive old ones
+	_, err = utils.ValidateJWT(legacyToken(time.Now()))
+	assert.Error(t, err)
+	_, err = utils.ValidateJWT(legacyToken(time.Now().Add(-20 * time.Minute)))
+	assert.Error(t, err)
+	noIssuedAt, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "user-1"}).SignedString([]byte("legacy-secret"))
+	require.NoError(t, err)
+	_, err = utils.ValidateJWT(noIssuedAt)
+	assert.Error(t, err)
+}
+
+func TestPublicJWKS(t *testing.T) {
+	rsaKey := newTestSigningKey(t, utils.AlgRS256, time.Now())
+	edKey := newTestSigningKey(t, utils.AlgEdDSA, time.Now())
+	utils.SetSigningKeys([]*utils.SigningKey{rsaKey, edKey})
+
+	byID := map[string]utils.JSONWebKey{}
+	for _, jwk := range utils.PublicJWKS().Keys {
+		byID[jwk.Kid] = jwk
+	}
+	assert.Equal(t, "RSA", byID[rsaKey.ID].Kty)
+	assert.Equal(t, "AQAB", byID[rsaKey.ID].E)
+	assert.NotEmpty(t, byID[rsaKey.ID].N)
+	assert.Equal(t, "OKP", byID[edKey.ID].Kty)
+	assert.Equal(t, "Ed25519", byID[edKey.ID].Crv)
+	assert.Equal(t, "EdDSA", byID[edKey.ID].Alg)
+}
+
+func TestPrivateKeyPEMRoundTrip(t *testing.T) {
+	for _, alg := range []string{utils.AlgRS256, utils.AlgEdDSA} {
+		key := newTestSigningKey(t, alg, time.Now())
+		encoded, err := utils.EncodePrivateKeyPEM(key.PrivateKey)
+		require.NoError(t, err)
+		decoded, err := utils.ParsePrivateKeyPEM(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, key.PrivateKey.Public(), decoded.Public())
+	}
+}
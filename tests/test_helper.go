@@ -1 +1,36 @@
- 
\ No newline at end of file
+package tests
+
+import (
+	"cbc-backend/config"
+	"cbc-backend/models"
+	"sync"
+	"testing"
+)
+
+// testDB is the result of setting up the test database, done once per run
+var testDB struct {
+	once sync.Once
+	err  error
+}
+
+// requireTestDB loads the configuration, connects to the test database and
+// creates its tables and a signing key. Tests that need the database are
+// skipped when it isn't available.
+func requireTestDB(t *testing.T) {
+	t.Helper()
+	testDB.once.Do(func() {
+		if testDB.err = config.LoadConfig(); testDB.err != nil {
+			return
+		}
+		if testDB.err = models.InitDB(testDBConn); testDB.err != nil {
+			return
+		}
+		if testDB.err = models.EnsureTables(); testDB.err != nil {
+			return
+		}
+		_, testDB.err = models.RotateSigningKeys(false)
+	})
+	if testDB.err != nil {
+		t.Skipf("test database unavailable: %v", testDB.err)
+	}
+}
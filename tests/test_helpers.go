@@ -2,6 +2,8 @@ package tests
 
 import (
 	"bytes"
+	"cbc-backend/models"
+	"cbc-backend/utils"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -33,6 +35,7 @@ func makeTestRequest(t *testing.T, method, path, body, token string) *httptest.R
 
 // createTestUser creates a test user and returns the JWT token
 func createTestUser(t *testing.T) string {
+	requireTestDB(t)
 	body := `{
 		"username": "testuserN",
 		"password": "password12N3",
@@ -59,6 +62,18 @@ func createTestUser(t *testing.T) string {
 	return data["token"].(string)
 }
 
+// verifyTestUser confirms the email address of the token's user, returning
+// the user's ID
+func verifyTestUser(t *testing.T, token string) string {
+	claims, err := utils.ValidateJWT(token)
+	assert.NoError(t, err)
+	verification, err := models.CreateEmailVerification(claims.UserID)
+	assert.NoError(t, err)
+	_, err = models.VerifyEmail(verification)
+	assert.NoError(t, err)
+	return claims.UserID
+}
+
 //nolint:unused
 func createTestJob(t *testing.T, token string) string {
 	jobBody := `{
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"cbc-backend/utils"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test vectors for SHA-1, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := utils.TOTPCode(secret, utils.TOTPCounter(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, code, "time %d", tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := utils.GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, err := utils.TOTPCode(secret, utils.TOTPCounter(now))
+	assert.NoError(t, err)
+
+	step, ok := utils.ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, utils.TOTPCounter(now), step)
+
+	// One step of clock drift is tolerated, more is not
+	_, ok = utils.ValidateTOTP(secret, code, now.Add(utils.TOTPPeriod))
+	assert.True(t, ok)
+	_, ok = utils.ValidateTOTP(secret, code, now.Add(3*utils.TOTPPeriod))
+	assert.False(t, ok)
+
+	_, ok = utils.ValidateTOTP(secret, "abc", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := utils.TOTPProvisioningURI("CBC Exams", "alice", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/CBC%20Exams:alice?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=CBC%20Exams")
+}
@@ -3,19 +3,21 @@ package tests
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"regexp"
 	"testing"
 
-	"cbc-backend/context"
+	"cbc-backend/mailer"
 	"cbc-backend/utils"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestUserSignup(t *testing.T) {
+	requireTestDB(t)
+
 	body := `{
 		"username": "newuser",
-		"password": "password123",
+		"password": "correct-horse-battery",
 		"email": "new@example.com",
 		"role": "teacher"
 	}`
@@ -49,25 +51,43 @@ func TestUserLogin(t *testing.T) {
 }
 
 func TestInvalidLogin(t *testing.T) {
+	requireTestDB(t)
+
 	body := `{
 		"username": "nonexistent",
 		"password": "wrongpass"
 	}`
 
 	w := makeTestRequest(t, "POST", "/v1/user/login", body, "")
-	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 401, w.Code)
 
-	var response map[string]string
+	var response map[string]interface{}
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
-	assert.Contains(t, response, "error")
+	assert.Equal(t, "Invalid username or password", response["message"])
+	assert.NotContains(t, response, "error")
+
+	// A wrong password for an existing user gets the same response
+	createTestUser(t)
+	w = makeTestRequest(t, "POST", "/v1/user/login", `{
+		"username": "testuserN",
+		"password": "wrongpass"
+	}`, "")
+	assert.Equal(t, 401, w.Code)
+
+	var existingResponse map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &existingResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, response, existingResponse)
 }
 
 func TestUserFlow(t *testing.T) {
+	requireTestDB(t)
+
 	// Test signup
 	signupBody := `{
 		"username": "testuser",
-		"password": "password123",
+		"password": "correct-horse-battery",
 		"email": "test@example.com",
 		"role": "teacher"
 	}`
@@ -78,7 +98,7 @@ func TestUserFlow(t *testing.T) {
 	// Test login
 	loginBody := `{
 		"username": "testuser",
-		"password": "password123"
+		"password": "correct-horse-battery"
 	}`
 
 	w = makeTestRequest(t, "POST", "/v1/user/login", loginBody, "")
@@ -99,14 +119,28 @@ func TestUserFlow(t *testing.T) {
 }
 
 func TestPasswordReset(t *testing.T) {
-	// Create test user
+	// Capture outgoing email instead of delivering it
+	capture := &captureMailer{}
+	mailer.Default = mailer.NewQueue(capture, 10, 1)
+	mailer.Default.Start(1)
+
+	// Create test user, signed in on another device
 	createTestUser(t)
+	w := makeTestRequest(t, "POST", "/v1/user/login", `{"username": "testuserN", "password": "password12N3"}`, "")
+	assert.Equal(t, 200, w.Code)
+	var login struct {
+		Data struct {
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.NotEmpty(t, login.Data.RefreshToken)
 
 	// Request password reset
 	forgotBody := `{
 		"email": "test@example.com"
 	}`
-	w := makeTestRequest(t, "POST", "/v1/user/forgot-password", forgotBody, "")
+	w = makeTestRequest(t, "POST", "/v1/user/forgot-password", forgotBody, "")
 	assert.Equal(t, 200, w.Code)
 
 	var response map[string]interface{}
@@ -114,8 +148,18 @@ func TestPasswordReset(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, response["success"].(bool))
 
-	data := response["data"].(map[string]interface{})
-	resetToken := data["reset_token"].(string)
+	// The token must only be delivered by email
+	assert.NotContains(t, response, "data")
+	mailer.Default.Close()
+	assert.Len(t, capture.sent, 1)
+	resetToken := regexp.MustCompile(`token=([A-Za-z0-9]+)`).FindStringSubmatch(capture.sent[0].Text)[1]
+
+	// Unknown emails get the same response
+	w = makeTestRequest(t, "POST", "/v1/user/forgot-password", `{"email": "nobody@example.com"}`, "")
+	assert.Equal(t, 200, w.Code)
+	var unknownResponse map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &unknownResponse))
+	assert.Equal(t, response, unknownResponse)
 
 	// Reset password
 	resetBody := fmt.Sprintf(`{
@@ -126,6 +170,10 @@ func TestPasswordReset(t *testing.T) {
 	w = makeTestRequest(t, "POST", "/v1/user/reset-password", resetBody, "")
 	assert.Equal(t, 200, w.Code)
 
+	// Devices signed in with the old password are signed out
+	w = makeTestRequest(t, "POST", "/v1/user/refresh", fmt.Sprintf(`{"refresh_token": %q}`, login.Data.RefreshToken), "")
+	assert.Equal(t, 401, w.Code)
+
 	// Try login with new password
 	loginBody := `{
 		"username": "testuserN",
@@ -140,12 +188,9 @@ func TestDeleteUser(t *testing.T) {
 	token := createTestUser(t)
 
 	// Get user ID from token
-	claims, err := utils.GetJWTClaims(&context.Context{Input: &context.BeegoInput{
-		RequestBody: []byte{},
-		Request:     &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + token}}},
-	}})
+	claims, err := utils.ValidateJWT(token)
 	assert.NoError(t, err)
-	userID := fmt.Sprintf("%v", claims["user_id"])
+	userID := claims.UserID
 
 	// Try to delete without token
 	w := makeTestRequest(t, "DELETE", "/v1/user/"+userID, "", "")
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 // Claims represents the JWT claims
@@ -13,23 +14,56 @@ type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	FamilyID string `json:"fid,omitempty"` // Refresh token family of the login
+	MFA      bool   `json:"mfa,omitempty"` // Login completed two-factor authentication
+	Purpose  string `json:"purpose,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateJWT generates a new JWT token
-func GenerateJWT(userID string, username string, role string) (string, error) {
+// PurposeMFAPending marks a token that only proves the password step of a
+// login; it must be exchanged together with a TOTP code for real tokens
+const PurposeMFAPending = "mfa_pending"
+
+// GenerateJWT generates a new short-lived access token. Each token carries a
+// unique ID (jti) so that it can be revoked before it expires, and the refresh
+// token family it was issued alongside so that logout can revoke both.
+func GenerateJWT(userID string, username string, role string, familyID string, mfa bool) (string, error) {
 	claims := Claims{
 		UserID:   userID,
 		Username: username,
 		Role:     role,
+		FamilyID: familyID,
+		MFA:      mfa,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			ExpiresAt: time.Now().Add(config.AccessTokenTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	tokenString, err := SignJWT(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	return tokenString, nil
+}
+
+// GenerateMFAPendingToken issues the short-lived token returned after the
+// password step of a login for a user with two-factor authentication
+func GenerateMFAPendingToken(userID string, username string) (string, error) {
+	claims := Claims{
+		UserID:   userID,
+		Username: username,
+		Purpose:  PurposeMFAPending,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+			Id:        uuid.New().String(),
+			ExpiresAt: time.Now().Add(config.MFAPendingTTL).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(config.JWTSecret)
+	tokenString, err := SignJWT(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %v", err)
 	}
@@ -37,16 +71,36 @@ func GenerateJWT(userID string, username string, role string) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateJWT validates a JWT token and returns the claims
+// ValidateMFAPendingToken validates a token from GenerateMFAPendingToken
+func ValidateMFAPendingToken(tokenString string) (*Claims, error) {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeMFAPending {
+		return nil, fmt.Errorf("not an MFA token")
+	}
+	return claims, nil
+}
+
+// ValidateJWT validates an access token and returns the claims
 func ValidateJWT(tokenString string) (*Claims, error) {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	// Special purpose tokens can't be used as access tokens
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// parseJWT verifies the signature and expiry of a token against the loaded
+// signing keys and returns its claims
+func parseJWT(tokenString string) (*Claims, error) {
 	// Parse the token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return config.JWTSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, JWTKeyFunc)
 
 	if err != nil {
 		// Add more detailed error logging
@@ -70,21 +124,3 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 
 	return nil, fmt.Errorf("invalid token")
 }
-
-// GetUserIDFromToken extracts the user ID from a token string
-func GetUserIDFromToken(tokenString string) (string, error) {
-	claims, err := ValidateJWT(tokenString)
-	if err != nil {
-		return "", err
-	}
-	return claims.UserID, nil
-}
-
-// GetUsernameFromToken extracts the username from a token string
-func GetUsernameFromToken(tokenString string) (string, error) {
-	claims, err := ValidateJWT(tokenString)
-	if err != nil {
-		return "", err
-	}
-	return claims.Username, nil
-}
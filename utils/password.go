@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bufio"
+	"cbc-backend/config"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordMaxBytes is the longest password bcrypt can hash without truncation
+const PasswordMaxBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the bundled list of banned passwords, lowercased
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+	return passwords
+}
+
+// PasswordViolation describes one way a password fails the policy
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every policy rule a password violates
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// CheckPasswordPolicy validates a new password for the account with the
+// given username and email. It returns a *PasswordPolicyError listing all
+// violations, or nil if the password is acceptable. Every place that sets a
+// password must call it.
+func CheckPasswordPolicy(password, username, email string) error {
+	var violations []PasswordViolation
+	lower := strings.ToLower(password)
+
+	if utf8.RuneCountInString(password) < config.PasswordMinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long", config.PasswordMinLength),
+		})
+	}
+
+	if len(password) > PasswordMaxBytes {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", PasswordMaxBytes),
+		})
+	}
+
+	if commonPasswords[lower] {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_common",
+			Message: "Password is too common",
+		})
+	}
+
+	if name := strings.ToLower(strings.TrimSpace(username)); len(name) >= 3 && strings.Contains(lower, name) {
+		violations = append(violations, PasswordViolation{
+			Code:    "contains_username",
+			Message: "Password must not contain your username",
+		})
+	}
+
+	// Check both the full address and its local part (before the @)
+	email = strings.ToLower(strings.TrimSpace(email))
+	localPart := email
+	if at := strings.Index(email, "@"); at >= 0 {
+		localPart = email[:at]
+	}
+	if len(localPart) >= 3 && strings.Contains(lower, localPart) {
+		violations = append(violations, PasswordViolation{
+			Code:    "contains_email",
+			Message: "Password must not contain your email address",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
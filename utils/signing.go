@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"cbc-backend/config"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beego/beego/v2/core/logs"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWT signing algorithms that keys can use
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA signing keys
+const rsaKeyBits = 2048
+
+// ErrNoSigningKey is returned when a token is signed before any key is loaded
+var ErrNoSigningKey = errors.New("no active JWT signing key")
+
+// SigningKey is a key pair that signs and verifies JWTs. Tokens name the key
+// in their kid header.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	// ActivatesAt is when the key starts signing tokens. Keys are published
+	// and accepted for verification before then, so that every server knows
+	// a key before it sees tokens signed with it.
+	ActivatesAt time.Time
+}
+
+// PublicKey returns the key that verifies the key's signatures
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// ValidSigningAlgorithm reports whether keys can be generated for alg
+func ValidSigningAlgorithm(alg string) bool {
+	return alg == AlgRS256 || alg == AlgEdDSA
+}
+
+// GenerateSigningKey creates a key pair for the algorithm with a random ID
+func GenerateSigningKey(alg string, activatesAt time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:          hex.EncodeToString(id),
+		Algorithm:   alg,
+		PrivateKey:  private,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// EncodePrivateKeyPEM encodes a private key as a PKCS #8 PEM block
+func EncodePrivateKeyPEM(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKeyPEM decodes a key written by EncodePrivateKeyPEM
+func ParsePrivateKeyPEM(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// keyRing holds the keys loaded by SetSigningKeys
+var keyRing struct {
+	sync.RWMutex
+	keys []*SigningKey // Newest activation first
+}
+
+// SetSigningKeys replaces the keys used to sign and verify tokens. Tokens
+// signed with a key that is left out stop being accepted.
+func SetSigningKeys(keys []*SigningKey) {
+	sorted := make([]*SigningKey, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.After(sorted[j].ActivatesAt)
+	})
+
+	keyRing.Lock()
+	defer keyRing.Unlock()
+	keyRing.keys = sorted
+}
+
+// currentSigningKey returns the most recently activated key
+func currentSigningKey() (*SigningKey, error) {
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+	now := time.Now()
+	for _, key := range keyRing.keys {
+		if !key.ActivatesAt.After(now) {
+			return key, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// lookupSigningKey finds a loaded key by ID
+func lookupSigningKey(kid string) *SigningKey {
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+	for _, key := range keyRing.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// SignJWT signs the claims with the current key and names it in the kid
+// header
+func SignJWT(claims jwt.Claims) (string, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// JWTKeyFunc returns the key that verifies the token, by its kid header.
+// The token's algorithm must be the key's. Tokens without a kid were issued
+// before asymmetric signing and are accepted with HS256 while JWT_SECRET is
+// still set, if they were issued before the oldest loaded key activated and
+// no longer than an access token lifetime ago.
+func JWTKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || len(config.JWTSecret) == 0 {
+			return nil, errors.New("token has no key ID")
+		}
+		if !legacyTokenAccepted(token.Claims) {
+			return nil, errors.New("legacy HS256 tokens are no longer accepted")
+		}
+		logs.Info("Accepting legacy HS256 token issued before asymmetric signing")
+		return config.JWTSecret, nil
+	}
+
+	key := lookupSigningKey(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey(), nil
+}
+
+// legacyTokenAccepted reports whether an HS256 token could have been issued
+// before asymmetric signing, so that JWT_SECRET can't mint new tokens after
+// the switch. A key is only deleted once every token it could have signed
+// has expired, so by the time the oldest key changes, tokens issued before
+// the new oldest key are already too old.
+func legacyTokenAccepted(claims jwt.Claims) bool {
+	c, ok := claims.(*Claims)
+	if !ok || c.IssuedAt == 0 {
+		return false
+	}
+	issuedAt := time.Unix(c.IssuedAt, 0)
+
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+	if len(keyRing.keys) == 0 {
+		return false
+	}
+	oldest := keyRing.keys[len(keyRing.keys)-1]
+	return issuedAt.Before(oldest.ActivatesAt) && time.Since(issuedAt) <= config.AccessTokenTTL
+}
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicJWKS returns the public halves of the loaded keys, including keys
+// that haven't started signing yet
+func PublicJWKS() JSONWebKeySet {
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range keyRing.keys {
+		jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+		switch public := key.PublicKey().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// SigningMethodEdDSA signs tokens with Ed25519 (RFC 8037), which jwt-go
+// doesn't provide
+var SigningMethodEdDSA jwt.SigningMethod = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgEdDSA, func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgEdDSA
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok || len(private) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok || len(public) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
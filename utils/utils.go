@@ -1,43 +1,33 @@
 package utils
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"strings"
-
-	"cbc-backend/config"
-
-	"github.com/beego/beego/v2/server/web/context"
-	"github.com/dgrijalva/jwt-go"
 )
 
+// GenerateRandomString returns a random alphanumeric string read from
+// crypto/rand. It panics if the system's secure random source fails.
 func GenerateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	max := big.NewInt(int64(len(charset)))
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		b[i] = charset[n.Int64()]
 	}
 	return string(b)
 }
 
-func GetJWTClaims(ctx *context.Context) (jwt.MapClaims, error) {
-	authHeader := ctx.Input.Header("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return nil, fmt.Errorf("invalid authorization header")
-	}
-
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return config.JWTSecret, nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
+// CSVSafe stops spreadsheet apps from running user supplied values, such as
+// attempted usernames, as formulas
+func CSVSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
 	}
-
-	return nil, fmt.Errorf("invalid token claims")
+	return value
 }